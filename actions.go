@@ -0,0 +1,138 @@
+package main
+
+import (
+	"log"
+	"sort"
+	"strings"
+	"time"
+)
+
+// todoActions are the shortcuts that act on the todo selected in the main
+// list, by name.
+var todoActions = map[string]func(*Todo, *TodoList){
+	"complete":      completeItem,
+	"delete":        func(todo *Todo, todoList *TodoList) { deleteItem(todo, todoList) },
+	"priority-up":   func(todo *Todo, _ *TodoList) { priorityUp(todo) },
+	"priority-down": func(todo *Todo, _ *TodoList) { priorityDown(todo) },
+	"postpone":      func(todo *Todo, _ *TodoList) { postpone(todo, time.Now()) },
+}
+
+// Default shortcut keys in the terminal UI
+var tuiKeys = map[string]string{
+	"c": "complete",
+	"d": "delete",
+	"+": "priority-up",
+	"-": "priority-down",
+	"p": "postpone",
+}
+
+// keyMenu is a Menu that can also report a shortcut key pressed on a line.
+type keyMenu interface {
+	// ShowKeys is Show where pressing one of the keys, which map to action
+	// names, returns the highlighted line and the key.
+	ShowKeys(list, prompt string, keys map[string]string) (out, key string, err error)
+	// DefaultKeys returns the keys to use when none are configured.
+	DefaultKeys() map[string]string
+}
+
+// showKeys shows list with the action shortcuts if the menu supports them.
+func showKeys(list, prompt string, keys map[string]string) (out, key string, err error) {
+	if km, ok := menu.(keyMenu); ok && len(keys) > 0 {
+		return km.ShowKeys(list, prompt, keys)
+	}
+	out, err = menu.Show(list, prompt)
+	return out, "", err
+}
+
+// menuKeys returns the shortcut keys for m, from a spec like
+// "Alt+c=complete,Alt+x=delete" or the menu's defaults if spec is empty.
+func menuKeys(m Menu, spec string) map[string]string {
+	if spec != "" {
+		return parseKeys(spec)
+	}
+	if km, ok := m.(keyMenu); ok {
+		return km.DefaultKeys()
+	}
+	return nil
+}
+
+// parseKeys parses comma separated key=action pairs, skipping unknown
+// actions.
+func parseKeys(spec string) map[string]string {
+	keys := make(map[string]string)
+	for _, pair := range strings.Split(spec, ",") {
+		i := strings.LastIndex(pair, "=")
+		if i < 1 {
+			log.Printf("Bad key binding %q, should be key=action", pair)
+			continue
+		}
+		key, action := strings.TrimSpace(pair[:i]), strings.TrimSpace(pair[i+1:])
+		if _, ok := todoActions[action]; !ok {
+			log.Printf("Unknown action %q for key %s", action, key)
+			continue
+		}
+		keys[key] = action
+	}
+	return keys
+}
+
+func sortedKeys(keys map[string]string) []string {
+	names := make([]string, 0, len(keys))
+	for key := range keys {
+		names = append(names, key)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// runAction runs the named action on todo. Unknown names do nothing.
+func runAction(name string, todo *Todo, todoList *TodoList) {
+	if action, ok := todoActions[name]; ok {
+		action(todo, todoList)
+	}
+}
+
+// priorityUp makes a todo more important. 1 is the highest priority and an
+// unset priority goes to the lowest, 9.
+func priorityUp(todo *Todo) {
+	switch {
+	case todo.Priority == 0:
+		todo.Priority = 9
+	case todo.Priority > 1:
+		todo.Priority--
+	default:
+		return
+	}
+	touch(todo)
+}
+
+// priorityDown makes a todo less important, unsetting the priority below 9.
+func priorityDown(todo *Todo) {
+	switch {
+	case todo.Priority == 9:
+		todo.Priority = 0
+	case todo.Priority > 0:
+		todo.Priority++
+	default:
+		return
+	}
+	touch(todo)
+}
+
+// postpone moves the due date on a day, keeping any time of day. Todos
+// without a due date become due tomorrow.
+func postpone(todo *Todo, now time.Time) {
+	if todo.DueDate.IsZero() {
+		today := now.Local()
+		todo.DueDate = time.Date(today.Year(), today.Month(), today.Day()+1, 0, 0, 0, 0, time.Local)
+		todo.DueKind = DateOnly
+	} else {
+		todo.DueDate = todo.DueDate.AddDate(0, 0, 1)
+	}
+	touch(todo)
+}
+
+func touch(todo *Todo) {
+	todo.LastMod = time.Now()
+	todo.Modified = true
+}
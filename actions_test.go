@@ -0,0 +1,52 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPriorityUpDown(t *testing.T) {
+	todo := &Todo{}
+	for _, expected := range []int{9, 8} {
+		priorityUp(todo)
+		if todo.Priority != expected {
+			t.Errorf("Expected priority %d, got %d", expected, todo.Priority)
+		}
+	}
+	todo.Priority = 1
+	priorityUp(todo)
+	if todo.Priority != 1 {
+		t.Errorf("Expected priority to stay at 1, got %d", todo.Priority)
+	}
+	todo.Priority = 9
+	priorityDown(todo)
+	if todo.Priority != 0 || !todo.Modified {
+		t.Errorf("Expected priority below 9 to be unset, got %d", todo.Priority)
+	}
+}
+
+func TestPostpone(t *testing.T) {
+	now := time.Date(2024, 9, 18, 15, 0, 0, 0, time.Local)
+	todo := &Todo{}
+	postpone(todo, now)
+	if formatDate(todo.DueDate) != "2024-09-19" || todo.DueKind != DateOnly {
+		t.Errorf("Expected all-day due tomorrow, got %v kind %v", todo.DueDate, todo.DueKind)
+	}
+	todo.DueDate = time.Date(2024, 9, 30, 9, 30, 0, 0, time.Local)
+	todo.DueKind = DateTimeUTC
+	postpone(todo, now)
+	if todo.DueDate != time.Date(2024, 10, 1, 9, 30, 0, 0, time.Local) {
+		t.Errorf("Expected due to move a day keeping the time, got %v", todo.DueDate)
+	}
+}
+
+func TestRunActionDelete(t *testing.T) {
+	todo := &Todo{UID: "a", Summary: "Gone", Status: "NEEDS-ACTION"}
+	todoList := &TodoList{Todos: []*Todo{todo}}
+	useMenu(t, "y")
+	runAction(tuiKeys["d"], todo, todoList)
+	if len(todoList.Todos) != 0 {
+		t.Error("Expected the delete shortcut to delete after confirming")
+	}
+	runAction("no-such-action", todo, todoList)
+}
@@ -0,0 +1,331 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	ics "github.com/arran4/golang-ical"
+)
+
+// Alarm is a VALARM reminder attached to a todo. Relative alarms fire at an
+// offset from DTSTART or DUE, absolute alarms at a fixed time.
+type Alarm struct {
+	Trigger  string        // Raw TRIGGER value, e.g. -PT15M or 20241001T090000Z
+	Related  string        // START or END (DUE) for relative triggers
+	Absolute bool          // TRIGGER;VALUE=DATE-TIME
+	Offset   time.Duration // Parsed relative trigger
+	At       time.Time     // Parsed absolute trigger
+}
+
+func convertVAlarms(vtodo *ics.VTodo) []Alarm {
+	var alarms []Alarm
+	for _, valarm := range vtodo.Alarms() {
+		trigger := valarm.GetProperty(ics.ComponentPropertyTrigger)
+		if trigger == nil {
+			continue
+		}
+		alarm, err := newAlarm(trigger.Value, trigger.ICalParameters)
+		if err != nil {
+			continue
+		}
+		alarms = append(alarms, alarm)
+	}
+	return alarms
+}
+
+func newAlarm(trigger string, params map[string][]string) (Alarm, error) {
+	alarm := Alarm{Trigger: trigger, Related: "START"}
+	if v := params[string(ics.ParameterValue)]; len(v) > 0 && strings.EqualFold(v[0], "DATE-TIME") {
+		alarm.Absolute = true
+		alarm.Related = ""
+		alarm.At = parseDateTime(trigger)
+		if alarm.At.IsZero() {
+			return alarm, fmt.Errorf("invalid alarm time %q", trigger)
+		}
+		return alarm, nil
+	}
+	if r := params[string(ics.ParameterRelated)]; len(r) > 0 {
+		alarm.Related = strings.ToUpper(r[0])
+	}
+	offset, err := parseICalDuration(trigger)
+	if err != nil {
+		return alarm, err
+	}
+	alarm.Offset = offset
+	return alarm, nil
+}
+
+// key identifies an alarm so that unchanged VALARMs can be kept as they are
+// when saving.
+func (a Alarm) key() string {
+	return a.Related + "|" + a.Trigger
+}
+
+// Time returns when the alarm fires for the given todo, or the zero time if
+// the date it is relative to isn't set. Alarms relative to the start of a
+// todo without one fire relative to its due date instead.
+func (a Alarm) Time(todo *Todo) time.Time {
+	if a.Absolute {
+		return a.At
+	}
+	base := todo.StartDate
+	if a.Related == "END" || base.IsZero() {
+		base = todo.DueDate
+	}
+	if base.IsZero() {
+		return time.Time{}
+	}
+	return base.Add(a.Offset)
+}
+
+func (a Alarm) String() string {
+	if a.Absolute {
+		return "at " + a.At.Local().Format("2006-01-02 15:04")
+	}
+	rel := "start"
+	if a.Related == "END" {
+		rel = "due"
+	}
+	switch {
+	case a.Offset == 0:
+		return "at " + rel
+	case a.Offset < 0:
+		return formatOffset(-a.Offset) + " before " + rel
+	default:
+		return formatOffset(a.Offset) + " after " + rel
+	}
+}
+
+func formatOffset(d time.Duration) string {
+	switch {
+	case d%(7*24*time.Hour) == 0:
+		return fmt.Sprintf("%dw", d/(7*24*time.Hour))
+	case d%(24*time.Hour) == 0:
+		return fmt.Sprintf("%dd", d/(24*time.Hour))
+	case d%time.Hour == 0:
+		return fmt.Sprintf("%dh", d/time.Hour)
+	default:
+		return fmt.Sprintf("%dm", d/time.Minute)
+	}
+}
+
+// parseICalDuration parses an RFC 5545 duration such as -PT15M or P1DT2H.
+func parseICalDuration(value string) (time.Duration, error) {
+	s := strings.ToUpper(strings.TrimSpace(value))
+	sign := time.Duration(1)
+	switch {
+	case strings.HasPrefix(s, "-"):
+		sign = -1
+		s = s[1:]
+	case strings.HasPrefix(s, "+"):
+		s = s[1:]
+	}
+	if !strings.HasPrefix(s, "P") || len(s) < 3 {
+		return 0, fmt.Errorf("invalid duration %q", value)
+	}
+	s = s[1:]
+
+	var d time.Duration
+	inTime := false
+	num := ""
+	// Each part, and the time part after T, needs at least one component
+	// (RFC 5545 3.3.6)
+	components := 0
+	for _, r := range s {
+		switch {
+		case r >= '0' && r <= '9':
+			num += string(r)
+			continue
+		case r == 'T' && !inTime && num == "":
+			inTime = true
+			components = 0
+			continue
+		}
+		n, err := strconv.Atoi(num)
+		if err != nil {
+			return 0, fmt.Errorf("invalid duration %q", value)
+		}
+		num = ""
+		unit := map[rune]time.Duration{'W': 7 * 24 * time.Hour, 'D': 24 * time.Hour}
+		if inTime {
+			unit = map[rune]time.Duration{'H': time.Hour, 'M': time.Minute, 'S': time.Second}
+		}
+		u, ok := unit[r]
+		if !ok {
+			return 0, fmt.Errorf("invalid duration %q", value)
+		}
+		d += time.Duration(n) * u
+		components++
+	}
+	if num != "" || components == 0 {
+		return 0, fmt.Errorf("invalid duration %q", value)
+	}
+	return sign * d, nil
+}
+
+// formatICalDuration is the inverse of parseICalDuration.
+func formatICalDuration(d time.Duration) string {
+	var b strings.Builder
+	if d < 0 {
+		b.WriteString("-")
+		d = -d
+	}
+	b.WriteString("P")
+	if days := d / (24 * time.Hour); days > 0 {
+		if days%7 == 0 && d%(24*time.Hour) == 0 {
+			fmt.Fprintf(&b, "%dW", days/7)
+			return b.String()
+		}
+		fmt.Fprintf(&b, "%dD", days)
+		d -= days * 24 * time.Hour
+	}
+	if d == 0 && b.Len() > 2 {
+		return b.String()
+	}
+	b.WriteString("T")
+	if h := d / time.Hour; h > 0 {
+		fmt.Fprintf(&b, "%dH", h)
+		d -= h * time.Hour
+	}
+	if m := d / time.Minute; m > 0 {
+		fmt.Fprintf(&b, "%dM", m)
+		d -= m * time.Minute
+	}
+	if s := d / time.Second; s > 0 || strings.HasSuffix(b.String(), "T") {
+		fmt.Fprintf(&b, "%dS", s)
+	}
+	return b.String()
+}
+
+// parseAlarmOffset accepts a shorthand like 15m, 2h, 1d or 1w, or an RFC
+// 5545 duration, and returns how long before the reference date the alarm
+// should fire.
+func parseAlarmOffset(value string) (time.Duration, error) {
+	s := strings.TrimSpace(value)
+	if s == "" || s == "0" {
+		return 0, nil
+	}
+	if strings.ContainsAny(strings.ToUpper(s), "P") {
+		return parseICalDuration(s)
+	}
+	units := map[byte]time.Duration{'m': time.Minute, 'h': time.Hour, 'd': 24 * time.Hour, 'w': 7 * 24 * time.Hour}
+	u, ok := units[s[len(s)-1]]
+	if !ok {
+		return 0, fmt.Errorf("invalid offset %q", value)
+	}
+	n, err := strconv.Atoi(s[:len(s)-1])
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid offset %q", value)
+	}
+	return -time.Duration(n) * u, nil
+}
+
+// setVAlarms updates the VALARM components of a VTODO to match the todo.
+// Existing alarms that are still wanted are kept untouched so any extra
+// properties survive; removed alarms are dropped and new ones are added as
+// DISPLAY alarms.
+func setVAlarms(vtodo *ics.VTodo, todo *Todo) {
+	wanted := make(map[string]bool)
+	for _, a := range todo.Alarms {
+		wanted[a.key()] = true
+	}
+
+	existing := make(map[string]bool)
+	components := vtodo.Components[:0]
+	for _, c := range vtodo.Components {
+		if valarm, ok := c.(*ics.VAlarm); ok {
+			trigger := valarm.GetProperty(ics.ComponentPropertyTrigger)
+			if trigger == nil {
+				components = append(components, c)
+				continue
+			}
+			alarm, err := newAlarm(trigger.Value, trigger.ICalParameters)
+			if err == nil && !wanted[alarm.key()] {
+				continue
+			}
+			existing[alarm.key()] = true
+		}
+		components = append(components, c)
+	}
+	vtodo.Components = components
+
+	for _, a := range todo.Alarms {
+		if existing[a.key()] {
+			continue
+		}
+		valarm := vtodo.AddAlarm()
+		valarm.SetAction(ics.ActionDisplay)
+		if a.Absolute {
+			valarm.SetTrigger(a.Trigger, ics.WithValue("DATE-TIME"))
+		} else if a.Related == "END" {
+			valarm.SetTrigger(a.Trigger, &ics.KeyValues{Key: string(ics.ParameterRelated), Value: []string{"END"}})
+		} else {
+			valarm.SetTrigger(a.Trigger)
+		}
+		valarm.SetProperty(ics.ComponentPropertyDescription, todo.Summary)
+	}
+}
+
+func editAlarms(todo *Todo) {
+	for {
+		var options strings.Builder
+		options.WriteString("Add alarm before due\nAdd alarm before start\nAdd alarm at date/time\n")
+		for _, a := range todo.Alarms {
+			fmt.Fprintf(&options, "Remove: %s\n", a)
+		}
+		out, e := menu.Show(options.String(), "Alarms:")
+		if e != nil {
+			return
+		}
+		switch {
+		case out == "Add alarm before due", out == "Add alarm before start":
+			related := "END"
+			if out == "Add alarm before start" {
+				related = "START"
+			}
+			o, e := menu.Show("0\n5m\n15m\n30m\n1h\n1d", "Time before (e.g. 15m, 2h, 1d or -PT15M):")
+			if e != nil {
+				continue
+			}
+			offset, err := parseAlarmOffset(o)
+			if err != nil {
+				menu.Show("", "Bad offset. Use e.g. 15m, 2h, 1d or -PT15M.")
+				continue
+			}
+			todo.Alarms = append(todo.Alarms, Alarm{
+				Trigger: formatICalDuration(offset),
+				Related: related,
+				Offset:  offset,
+			})
+			todo.Modified = true
+		case out == "Add alarm at date/time":
+			d, e := menu.Prompt("Alarm time (yyyy-mm-dd hh:mm):", "")
+			if e != nil {
+				continue
+			}
+			at, err := time.ParseInLocation("2006-01-02 15:04", d, time.Local)
+			if err != nil {
+				menu.Show("", "Bad date format. Should be yyyy-mm-dd hh:mm.")
+				continue
+			}
+			todo.Alarms = append(todo.Alarms, Alarm{
+				Trigger:  at.UTC().Format("20060102T150405Z"),
+				Absolute: true,
+				At:       at,
+			})
+			todo.Modified = true
+		case strings.HasPrefix(out, "Remove: "):
+			for i, a := range todo.Alarms {
+				if "Remove: "+a.String() == out {
+					todo.Alarms = append(todo.Alarms[:i:i], todo.Alarms[i+1:]...)
+					todo.Modified = true
+					break
+				}
+			}
+		default:
+			return
+		}
+	}
+}
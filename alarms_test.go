@@ -0,0 +1,133 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseICalDuration(t *testing.T) {
+	tests := map[string]time.Duration{
+		"PT0S":     0,
+		"-PT15M":   -15 * time.Minute,
+		"P1D":      24 * time.Hour,
+		"-P1DT2H":  -26 * time.Hour,
+		"P2W":      14 * 24 * time.Hour,
+		"+PT1H30M": 90 * time.Minute,
+	}
+	for value, expected := range tests {
+		d, err := parseICalDuration(value)
+		if err != nil {
+			t.Errorf("parseICalDuration(%q) failed: %v", value, err)
+			continue
+		}
+		if d != expected {
+			t.Errorf("parseICalDuration(%q) = %v, expected %v", value, d, expected)
+		}
+		if back, _ := parseICalDuration(formatICalDuration(d)); back != d {
+			t.Errorf("formatICalDuration(%v) = %q does not round trip", d, formatICalDuration(d))
+		}
+	}
+	for _, value := range []string{"", "15M", "P", "-PT", "P1DT", "PTT1H", "P1H", "PT1D"} {
+		if _, err := parseICalDuration(value); err == nil {
+			t.Errorf("Expected error for %q", value)
+		}
+	}
+}
+
+func TestAlarmTimeWithoutStart(t *testing.T) {
+	due := time.Date(2024, 10, 1, 17, 0, 0, 0, time.UTC)
+	alarm := Alarm{Trigger: "-PT15M", Related: "START", Offset: -15 * time.Minute}
+	if at := alarm.Time(&Todo{DueDate: due}); !at.Equal(due.Add(-15 * time.Minute)) {
+		t.Errorf("Expected the alarm relative to DUE without a DTSTART, got %v", at)
+	}
+	start := due.Add(-8 * time.Hour)
+	if at := alarm.Time(&Todo{DueDate: due, StartDate: start}); !at.Equal(start.Add(-15 * time.Minute)) {
+		t.Errorf("Expected the alarm relative to DTSTART, got %v", at)
+	}
+}
+
+func TestParseAlarmOffset(t *testing.T) {
+	tests := map[string]time.Duration{
+		"0":      0,
+		"15m":    -15 * time.Minute,
+		"2h":     -2 * time.Hour,
+		"1d":     -24 * time.Hour,
+		"-PT30M": -30 * time.Minute,
+	}
+	for value, expected := range tests {
+		if d, err := parseAlarmOffset(value); err != nil || d != expected {
+			t.Errorf("parseAlarmOffset(%q) = %v, %v, expected %v", value, d, err, expected)
+		}
+	}
+	if _, err := parseAlarmOffset("soon"); err == nil {
+		t.Error("Expected error for 'soon'")
+	}
+}
+
+func TestLoadAlarms(t *testing.T) {
+	todoList, err := loadTodos("testdata")
+	if err != nil {
+		t.Fatalf("Failed to load todos: %v", err)
+	}
+	todo := findTodoByUID(todoList, "3900172495289256706")
+	if todo == nil {
+		t.Fatal("Todo with UID 3900172495289256706 not found")
+	}
+	if len(todo.Alarms) != 1 {
+		t.Fatalf("Expected 1 alarm, got %d", len(todo.Alarms))
+	}
+	alarm := todo.Alarms[0]
+	if alarm.Related != "END" || alarm.Offset != 0 || alarm.String() != "at due" {
+		t.Errorf("Unexpected alarm %+v", alarm)
+	}
+	if !alarm.Time(todo).Equal(todo.DueDate) {
+		t.Errorf("Expected alarm at due date %v, got %v", todo.DueDate, alarm.Time(todo))
+	}
+}
+
+func TestSaveAlarms(t *testing.T) {
+	tempDir := t.TempDir()
+	data, err := os.ReadFile(filepath.Join("testdata", "3900172495289256706.ics"))
+	if err != nil {
+		t.Fatalf("Failed to read test file: %v", err)
+	}
+	filePath := filepath.Join(tempDir, "3900172495289256706.ics")
+	if err := os.WriteFile(filePath, data, 0644); err != nil {
+		t.Fatalf("Failed to write test file: %v", err)
+	}
+
+	todoList, err := loadTodos(tempDir)
+	if err != nil {
+		t.Fatalf("Failed to load todos: %v", err)
+	}
+	todo := todoList.Todos[0]
+	todo.Alarms = append(todo.Alarms, Alarm{Trigger: "-PT15M", Related: "START", Offset: -15 * time.Minute})
+	todo.Modified = true
+	if err := saveTodos(todoList, tempDir); err != nil {
+		t.Fatalf("Failed to save todos: %v", err)
+	}
+
+	saved, _ := os.ReadFile(filePath)
+	if !strings.Contains(string(saved), "DESCRIPTION:Default Tasks.org description") {
+		t.Error("Expected existing alarm to be kept unchanged")
+	}
+	if !strings.Contains(string(saved), "TRIGGER:-PT15M") {
+		t.Error("Expected new alarm to be added")
+	}
+
+	// Remove the original alarm
+	todoList, _ = loadTodos(tempDir)
+	todo = todoList.Todos[0]
+	todo.Alarms = todo.Alarms[1:]
+	todo.Modified = true
+	if err := saveTodos(todoList, tempDir); err != nil {
+		t.Fatalf("Failed to save todos: %v", err)
+	}
+	saved, _ = os.ReadFile(filePath)
+	if strings.Contains(string(saved), "RELATED=END") || strings.Count(string(saved), "BEGIN:VALARM") != 1 {
+		t.Errorf("Expected only the new alarm to remain, got:\n%s", saved)
+	}
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// multiMenu is a Menu that can pick several lines at once. ShowMulti
+// returns errors.ErrUnsupported if the launcher has no multi-select.
+type multiMenu interface {
+	ShowMulti(list, prompt string) ([]string, error)
+}
+
+const doneSelecting = "Done selecting"
+
+// Actions offered for a bulk edit
+var bulkActions = []string{
+	"Complete",
+	"Delete",
+	"Set priority",
+	"Add category",
+	"Remove category",
+	"Set due date",
+	"Postpone one day",
+}
+
+// selectMany lets the user pick several of lines, with the launcher's
+// multi-select if it has one and otherwise by picking lines one at a time.
+func selectMany(lines []string, prompt string) ([]string, error) {
+	if mm, ok := menu.(multiMenu); ok {
+		chosen, err := mm.ShowMulti(strings.Join(lines, "\n"), prompt)
+		if !errors.Is(err, errors.ErrUnsupported) {
+			return chosen, err
+		}
+	}
+
+	selected := make(map[string]bool)
+	for {
+		var list strings.Builder
+		fmt.Fprintf(&list, "%s (%d)\n", doneSelecting, len(selected))
+		for _, line := range lines {
+			mark := "[ ] "
+			if selected[line] {
+				mark = "[x] "
+			}
+			list.WriteString(mark + line + "\n")
+		}
+		out, err := menu.Show(list.String(), prompt+" (pick again to unselect)")
+		if err != nil {
+			return nil, err
+		}
+		if strings.HasPrefix(out, doneSelecting) {
+			break
+		}
+		line := strings.TrimPrefix(strings.TrimPrefix(out, "[ ] "), "[x] ")
+		selected[line] = !selected[line]
+	}
+
+	var chosen []string
+	for _, line := range lines {
+		if selected[line] {
+			chosen = append(chosen, line)
+		}
+	}
+	return chosen, nil
+}
+
+// bulkEdit picks several todos from the main list and applies one action to
+// all of them. The changes are saved with everything else on exit.
+func bulkEdit(todoList *TodoList) {
+	list := createMenu(todoList, openItems)
+	chosen, err := selectMany(list.todoLines(), "Select items:")
+	if err != nil {
+		return
+	}
+	var todos []*Todo
+	for _, line := range chosen {
+		if todo := list.lookup(line); todo != nil {
+			todos = append(todos, todo)
+		}
+	}
+	if len(todos) == 0 {
+		return
+	}
+
+	action, err := menu.Show(strings.Join(bulkActions, "\n"), fmt.Sprintf("%d items:", len(todos)))
+	if err != nil {
+		return
+	}
+	switch action {
+	case "Complete":
+		for _, todo := range todos {
+			completeTodo(todo)
+		}
+	case "Delete":
+		if !menu.Confirm(fmt.Sprintf("Delete %d items?", len(todos))) {
+			return
+		}
+		// Subtasks that aren't selected move up to their nearest ancestor
+		// that is kept
+		deleteTodos(todos, todoList)
+	case "Set priority":
+		p, err := menu.Prompt("Priority (0-9, 0 to unset):", "")
+		if err != nil {
+			return
+		}
+		pn, err := strconv.Atoi(p)
+		if err != nil || pn < 0 || pn > 9 {
+			menu.Show("", "Priority must be a number between 0 and 9")
+			return
+		}
+		for _, todo := range todos {
+			todo.Priority = pn
+			touch(todo)
+		}
+	case "Add category", "Remove category":
+		cat, err := menu.Show(strings.Join(getExistingCategories(todoList), "\n"), action+":")
+		if cat = strings.TrimSpace(cat); err != nil || cat == "" {
+			return
+		}
+		for _, todo := range todos {
+			if action == "Add category" {
+				addCategory(todo, cat)
+			} else {
+				removeCategory(todo, cat)
+			}
+		}
+	case "Set due date":
+		d, err := menu.Show(dateOptions(""), "Due Date (yyyy-mm-dd, tomorrow, fri, +3d...):")
+		if err != nil {
+			return
+		}
+		// Check the date once so a bad one changes nothing
+		if _, _, err := parseDateInput(d, time.Now()); d != "" && err != nil {
+			menu.Show("", "Bad date format. Try yyyy-mm-dd, tomorrow, next mon or +3d.")
+			return
+		}
+		for _, todo := range todos {
+			updateDueDate(todo, d)
+			todo.LastMod = time.Now()
+		}
+	case "Postpone one day":
+		for _, todo := range todos {
+			postpone(todo, time.Now())
+		}
+	}
+}
+
+func addCategory(todo *Todo, cat string) {
+	if slices.Contains(todo.Categories, cat) {
+		return
+	}
+	todo.Categories = append(todo.Categories, cat)
+	touch(todo)
+}
+
+func removeCategory(todo *Todo, cat string) {
+	for i, c := range todo.Categories {
+		if c == cat {
+			todo.Categories = append(todo.Categories[:i:i], todo.Categories[i+1:]...)
+			touch(todo)
+			return
+		}
+	}
+}
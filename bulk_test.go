@@ -0,0 +1,144 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func bulkTestList() *TodoList {
+	return &TodoList{Todos: []*Todo{
+		{UID: "a", Summary: "Milk", Status: "NEEDS-ACTION", Categories: []string{"home"}},
+		{UID: "b", Summary: "Bread", Status: "NEEDS-ACTION"},
+		{UID: "c", Summary: "Invoice", Status: "NEEDS-ACTION", Categories: []string{"work"}},
+	}}
+}
+
+// menuLine returns the main list line for the todo with summary.
+func menuLine(t *testing.T, todoList *TodoList, summary string) string {
+	t.Helper()
+	for _, line := range createMenu(todoList, openItems).todoLines() {
+		if strings.Contains(line, " "+summary) {
+			return line
+		}
+	}
+	t.Fatalf("No menu line for %q", summary)
+	return ""
+}
+
+func TestBulkEditRepeatedSelection(t *testing.T) {
+	todoList := bulkTestList()
+	milk, bread, invoice := menuLine(t, todoList, "Milk"), menuLine(t, todoList, "Bread"), menuLine(t, todoList, "Invoice")
+	useMenu(t,
+		"[ ] "+milk, "[ ] "+invoice, "[ ] "+bread,
+		"[x] "+invoice, // Picking again unselects
+		"Done selecting (2)",
+		"Add category", "errands",
+	)
+	bulkEdit(todoList)
+	for _, todo := range todoList.Todos {
+		expected := todo.UID != "c"
+		if containsCategory(todo.Categories, "errands") != expected || todo.Modified != expected {
+			t.Errorf("%s: categories %v, modified %v", todo.Summary, todo.Categories, todo.Modified)
+		}
+	}
+}
+
+// scriptedMultiMenu adds multi-select to scriptedMenu.
+type scriptedMultiMenu struct {
+	*scriptedMenu
+	chosen []string
+}
+
+func (s *scriptedMultiMenu) ShowMulti(list, prompt string) ([]string, error) {
+	return s.chosen, nil
+}
+
+func TestBulkEditMultiSelect(t *testing.T) {
+	todoList := bulkTestList()
+	s := &scriptedMultiMenu{scriptedMenu: useMenu(t)}
+	s.chosen = []string{menuLine(t, todoList, "Milk"), menuLine(t, todoList, "Invoice")}
+	menu = s
+
+	s.answers = []string{"Set priority", "2"}
+	bulkEdit(todoList)
+	s.chosen = []string{menuLine(t, todoList, "Milk"), menuLine(t, todoList, "Invoice")}
+	s.answers = []string{"Remove category", "home"}
+	bulkEdit(todoList)
+	for _, todo := range todoList.Todos {
+		if (todo.Priority == 2) != (todo.UID != "b") {
+			t.Errorf("%s: unexpected priority %d", todo.Summary, todo.Priority)
+		}
+		if containsCategory(todo.Categories, "home") {
+			t.Errorf("%s: expected home to be removed", todo.Summary)
+		}
+	}
+
+	s.chosen = []string{menuLine(t, todoList, "Milk"), menuLine(t, todoList, "Invoice")}
+	s.answers = []string{"Delete", "y"}
+	bulkEdit(todoList)
+	if len(todoList.Todos) != 1 || todoList.Todos[0].UID != "b" {
+		t.Errorf("Expected only Bread left, got %d todos", len(todoList.Todos))
+	}
+}
+
+func TestBulkEditDeleteSubtasks(t *testing.T) {
+	todoList := &TodoList{Todos: []*Todo{
+		{UID: "p", Summary: "Plan party", Status: "NEEDS-ACTION"},
+		{UID: "a", Summary: "Buy food", ParentUID: "p", Status: "NEEDS-ACTION"},
+		{UID: "c", Summary: "Cheese", ParentUID: "a", Status: "NEEDS-ACTION"},
+		{UID: "g", Summary: "Goat cheese", ParentUID: "c", Status: "NEEDS-ACTION"},
+		{UID: "d", Summary: "Drinks", ParentUID: "a", Status: "NEEDS-ACTION"},
+	}}
+	s := &scriptedMultiMenu{scriptedMenu: useMenu(t, "Delete", "y")}
+	s.chosen = []string{menuLine(t, todoList, "Buy food"), menuLine(t, todoList, "Cheese")}
+	menu = s
+	bulkEdit(todoList)
+	if len(todoList.Todos) != 3 {
+		t.Fatalf("Expected 3 todos left, got %d", len(todoList.Todos))
+	}
+	for _, uid := range []string{"g", "d"} {
+		if todo := findTodo(todoList, uid); todo.ParentUID != "p" || !todo.Modified {
+			t.Errorf("Expected %s to move up to p, got %q", uid, todo.ParentUID)
+		}
+	}
+}
+
+func TestBulkEditBadDueDate(t *testing.T) {
+	todoList := bulkTestList()
+	s := &scriptedMultiMenu{scriptedMenu: useMenu(t, "Set due date", "someday", "")}
+	s.chosen = []string{menuLine(t, todoList, "Milk"), menuLine(t, todoList, "Invoice")}
+	menu = s
+	bulkEdit(todoList)
+	for _, todo := range todoList.Todos {
+		if !todo.DueDate.IsZero() || todo.Modified {
+			t.Errorf("Expected %s to be unchanged after a bad date", todo.Summary)
+		}
+	}
+	if !slicesContainsPrefix(s.prompts, "Bad date format") {
+		t.Errorf("Expected an error message, prompts were %q", s.prompts)
+	}
+}
+
+func TestLauncherMenuShowMulti(t *testing.T) {
+	path, argsFile := fakeLauncher(t, "rofi", "0 a\\n2 c\\n", 0)
+	chosen, err := newMenu(path, "").(multiMenu).ShowMulti("a\nb\nc", "Pick:")
+	if err != nil || !reflect.DeepEqual(chosen, []string{"a", "c"}) {
+		t.Errorf("Expected a and c, got %q, %v", chosen, err)
+	}
+	if data, _ := os.ReadFile(argsFile); !strings.Contains(string(data), "-multi-select") {
+		t.Errorf("Expected -multi-select, got %q", data)
+	}
+
+	path, _ = fakeLauncher(t, "fzf", "\\n1\\tb\\n2\\tc\\n", 0)
+	chosen, err = newMenu(path, "").(multiMenu).ShowMulti("a\nb\nc", "Pick:")
+	if err != nil || !reflect.DeepEqual(chosen, []string{"b", "c"}) {
+		t.Errorf("Expected b and c, got %q, %v", chosen, err)
+	}
+
+	if _, err := newMenu("dmenu", "").(multiMenu).ShowMulti("a", "Pick:"); !errors.Is(err, errors.ErrUnsupported) {
+		t.Errorf("Expected dmenu to have no multi-select, got %v", err)
+	}
+}
@@ -0,0 +1,478 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Exit codes for the command line interface
+const (
+	exitOK        = 0
+	exitError     = 1
+	exitUsage     = 2
+	exitNotFound  = 3
+	exitAmbiguous = 4
+)
+
+var errNotFound = errors.New("no matching todo")
+var errAmbiguous = errors.New("more than one matching todo")
+
+const cliUsage = `Usage: todocalmenu [flags] <command> [args]
+
+Commands:
+  add [-json] [-list name] <title>
+                               Add a todo; quick-add tokens like due:fri work
+  list [-json] [-list names] [-filter query] [-completed|-cancelled|-all]
+                               List open todos as "UID<TAB>line"
+  show [-json] <uid|query>     Show all fields of a todo
+  done <uid|query>             Complete a todo (or advance a recurring one)
+  edit [flags] <uid|query>     Change fields; see "todocalmenu edit -h"
+  rm <uid|query>               Delete a todo
+  split [-n]                   Split files holding more than one todo, event
+                               or journal into one file per UID; -n only shows
+                               what would be split
+
+A query matches a UID exactly or part of a summary, ignoring case. Exit codes
+are 0 on success, 1 on errors, 2 for bad usage, 3 if nothing matched and 4 if
+the query matched more than one todo.
+`
+
+// todoJSON is the machine readable form of a todo.
+type todoJSON struct {
+	UID          string   `json:"uid"`
+	Summary      string   `json:"summary"`
+	Description  string   `json:"description,omitempty"`
+	Status       string   `json:"status"`
+	Percent      int      `json:"percent_complete,omitempty"`
+	Priority     int      `json:"priority,omitempty"`
+	Categories   []string `json:"categories,omitempty"`
+	Due          string   `json:"due,omitempty"`
+	Start        string   `json:"start,omitempty"`
+	Completed    string   `json:"completed,omitempty"`
+	Created      string   `json:"created,omitempty"`
+	LastModified string   `json:"last_modified,omitempty"`
+	RRule        string   `json:"rrule,omitempty"`
+	Parent       string   `json:"parent,omitempty"`
+	List         string   `json:"list,omitempty"`
+	File         string   `json:"file,omitempty"`
+}
+
+func newTodoJSON(todo *Todo) todoJSON {
+	return todoJSON{
+		UID:          todo.UID,
+		Summary:      todo.Summary,
+		Description:  todo.Description,
+		Status:       todo.Status,
+		Percent:      todo.Percent,
+		Priority:     todo.Priority,
+		Categories:   todo.Categories,
+		Due:          formatJSONTime(todo.DueDate, todo.DueKind),
+		Start:        formatJSONTime(todo.StartDate, todo.StartKind),
+		Completed:    formatJSONTime(todo.Completed, DateTimeUTC),
+		Created:      formatJSONTime(todo.Created, DateTimeUTC),
+		LastModified: formatJSONTime(todo.LastMod, DateTimeUTC),
+		RRule:        todo.RRule,
+		Parent:       todo.ParentUID,
+		List:         listName(todo),
+		File:         todo.FileName,
+	}
+}
+
+func formatJSONTime(t time.Time, kind DateKind) string {
+	if t.IsZero() {
+		return ""
+	}
+	if kind == DateOnly {
+		return t.Format("2006-01-02")
+	}
+	return t.Format(time.RFC3339)
+}
+
+// runCommand runs a non-interactive subcommand against the todo
+// directories in the -todo value dirs and returns the process exit code.
+func runCommand(args []string, dirs string, stdout, stderr io.Writer) int {
+	if len(args) == 0 {
+		fmt.Fprint(stderr, cliUsage)
+		return exitUsage
+	}
+	collections, err := findCollections(dirs)
+	if err != nil {
+		fmt.Fprintf(stderr, "todocalmenu: %v\n", err)
+		return exitError
+	}
+	todoList, err := loadCollections(collections)
+	if err != nil {
+		fmt.Fprintf(stderr, "todocalmenu: %v\n", err)
+		return exitError
+	}
+
+	// Output is held back until the changes are saved, so a UID is never
+	// printed for a todo that wasn't written
+	var out bytes.Buffer
+	var code int
+	switch args[0] {
+	case "add":
+		code = cmdAdd(todoList, args[1:], &out, stderr)
+	case "list", "ls":
+		code = cmdList(todoList, args[1:], &out, stderr)
+	case "show":
+		code = cmdShow(todoList, args[1:], &out, stderr)
+	case "done":
+		code = cmdDone(todoList, args[1:], &out, stderr)
+	case "edit":
+		code = cmdEdit(todoList, args[1:], &out, stderr)
+	case "rm":
+		code = cmdRm(todoList, args[1:], &out, stderr)
+	case "split":
+		code = cmdSplit(todoList, args[1:], &out, stderr)
+	case "help", "-h", "-help", "--help":
+		fmt.Fprint(stdout, cliUsage)
+		return exitOK
+	default:
+		fmt.Fprintf(stderr, "todocalmenu: unknown command %q\n\n%s", args[0], cliUsage)
+		return exitUsage
+	}
+	if code != exitOK {
+		io.Copy(stdout, &out)
+		return code
+	}
+
+	if err := saveTodos(todoList, collections[0].Dir); err != nil {
+		fmt.Fprintf(stderr, "todocalmenu: %v\n", err)
+		return exitError
+	}
+	io.Copy(stdout, &out)
+	return exitOK
+}
+
+func newFlagSet(name string, stderr io.Writer) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	return fs
+}
+
+func cmdAdd(todoList *TodoList, args []string, stdout, stderr io.Writer) int {
+	fs := newFlagSet("add", stderr)
+	jsonOut := fs.Bool("json", false, "Print the new todo as JSON")
+	list := fs.String("list", "", "List to add the todo to (default the first one)")
+	if err := fs.Parse(args); err != nil {
+		return exitUsage
+	}
+	todo := newTodo("")
+	applyQuickAdd(todo, strings.Join(fs.Args(), " "), time.Now())
+	if todo.Summary == "" {
+		fmt.Fprintln(stderr, "todocalmenu: add needs a title")
+		return exitUsage
+	}
+	if *list != "" {
+		todo.Collection = findCollection(todoList, *list)
+		if todo.Collection == nil {
+			fmt.Fprintf(stderr, "todocalmenu: unknown list %q\n", *list)
+			return exitUsage
+		}
+	} else if len(todoList.Collections) > 0 {
+		todo.Collection = todoList.Collections[0]
+	}
+	todo.Modified = true
+	todoList.Todos = append(todoList.Todos, todo)
+
+	if *jsonOut {
+		return writeJSON(stdout, stderr, newTodoJSON(todo))
+	}
+	fmt.Fprintln(stdout, todo.UID)
+	return exitOK
+}
+
+func cmdList(todoList *TodoList, args []string, stdout, stderr io.Writer) int {
+	fs := newFlagSet("list", stderr)
+	jsonOut := fs.Bool("json", false, "Print todos as a JSON array")
+	completed := fs.Bool("completed", false, "List completed todos instead")
+	cancelled := fs.Bool("cancelled", false, "List cancelled todos instead")
+	all := fs.Bool("all", false, "List open, completed and cancelled todos")
+	lists := fs.String("list", *listFilterPtr, "Only list todos in these comma separated lists")
+	query := fs.String("filter", filterQuery(), "Only list todos matching a filter query")
+	if err := fs.Parse(args); err != nil {
+		return exitUsage
+	}
+	defer func(old string, f *todoFilter) { *listFilterPtr, activeFilter = old, f }(*listFilterPtr, activeFilter)
+	*listFilterPtr = *lists
+	if err := setFilter(*query); err != nil {
+		fmt.Fprintf(stderr, "todocalmenu: %v\n", err)
+		return exitUsage
+	}
+
+	sortTodos(todoList)
+	kind := openItems
+	if *completed {
+		kind = completedItems
+	} else if *cancelled {
+		kind = cancelledItems
+	}
+	todos := visibleTodos(todoList, kind)
+	if *all {
+		todos = append(visibleTodos(todoList, openItems), visibleTodos(todoList, completedItems)...)
+		todos = append(todos, visibleTodos(todoList, cancelledItems)...)
+	}
+	ordered, depths := treeOrder(todos)
+
+	if *jsonOut {
+		list := make([]todoJSON, 0, len(ordered))
+		for _, todo := range ordered {
+			list = append(list, newTodoJSON(todo))
+		}
+		return writeJSON(stdout, stderr, list)
+	}
+	for n, todo := range ordered {
+		fmt.Fprintf(stdout, "%s\t%s\n", todo.UID, formatTodoLine(todoList, todo, depths[n]))
+	}
+	return exitOK
+}
+
+func cmdShow(todoList *TodoList, args []string, stdout, stderr io.Writer) int {
+	fs := newFlagSet("show", stderr)
+	jsonOut := fs.Bool("json", false, "Print the todo as JSON")
+	if err := fs.Parse(args); err != nil {
+		return exitUsage
+	}
+	todo, code := resolveTodoArg(todoList, fs.Args(), false, stderr)
+	if todo == nil {
+		return code
+	}
+
+	j := newTodoJSON(todo)
+	if *jsonOut {
+		return writeJSON(stdout, stderr, j)
+	}
+	fields := []struct{ name, value string }{
+		{"UID", j.UID},
+		{"Summary", j.Summary},
+		{"Status", j.Status},
+		{"Progress", strconv.Itoa(j.Percent) + "%"},
+		{"Priority", strconv.Itoa(j.Priority)},
+		{"Categories", strings.Join(j.Categories, ",")},
+		{"Due", j.Due},
+		{"Start", j.Start},
+		{"Repeat", formatRRule(j.RRule)},
+		{"Parent", parentSummary(todo, todoList)},
+		{"List", j.List},
+		{"Completed", j.Completed},
+		{"Created", j.Created},
+		{"Last modified", j.LastModified},
+		{"File", j.File},
+		{"Description", j.Description},
+	}
+	for _, f := range fields {
+		fmt.Fprintf(stdout, "%s: %s\n", f.name, f.value)
+	}
+	return exitOK
+}
+
+func cmdDone(todoList *TodoList, args []string, stdout, stderr io.Writer) int {
+	todo, code := resolveTodoArg(todoList, args, true, stderr)
+	if todo == nil {
+		return code
+	}
+	completeTodo(todo)
+	if todo.Status == "COMPLETED" {
+		fmt.Fprintf(stdout, "Completed: %s\n", todo.Summary)
+	} else {
+		fmt.Fprintf(stdout, "Next occurrence: %s due:%s\n", todo.Summary, formatDate(todo.DueDate))
+	}
+	return exitOK
+}
+
+func cmdEdit(todoList *TodoList, args []string, stdout, stderr io.Writer) int {
+	fs := newFlagSet("edit", stderr)
+	summary := fs.String("summary", "", "New title")
+	description := fs.String("description", "", "New description")
+	priority := fs.Int("priority", 0, "Priority 0-9, 0 to unset")
+	status := fs.String("status", "", "NEEDS-ACTION, IN-PROCESS, COMPLETED or CANCELLED")
+	percent := fs.Int("percent", 0, "Percent complete 0-100")
+	categories := fs.String("cat", "", "Comma separated categories, empty to clear")
+	due := fs.String("due", "", "Due date (yyyy-mm-dd, tomorrow, +3d...), empty to clear")
+	start := fs.String("start", "", "Start date, empty to clear")
+	rrule := fs.String("rrule", "", "RRULE, empty to stop repeating")
+	fs.Usage = func() {
+		fmt.Fprintln(stderr, "Usage: todocalmenu edit [flags] <uid|query>")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return exitUsage
+	}
+	todo, code := resolveTodoArg(todoList, fs.Args(), false, stderr)
+	if todo == nil {
+		return code
+	}
+
+	var errs []error
+	fs.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "summary":
+			todo.Summary = *summary
+		case "description":
+			todo.Description = *description
+		case "priority":
+			if *priority < 0 || *priority > 9 {
+				errs = append(errs, fmt.Errorf("priority must be a number between 0 and 9"))
+			}
+			todo.Priority = *priority
+		case "status":
+			s := strings.ToUpper(*status)
+			if !slices.Contains(todoStatuses, s) {
+				errs = append(errs, fmt.Errorf("status must be one of %s", strings.Join(todoStatuses, ", ")))
+				return
+			}
+			setStatus(todo, s, time.Now())
+		case "percent":
+			if *percent < 0 || *percent > 100 {
+				errs = append(errs, fmt.Errorf("percent must be a number between 0 and 100"))
+			}
+			todo.Percent = *percent
+		case "cat":
+			todo.Categories = nil
+			for _, cat := range strings.Split(*categories, ",") {
+				if cat = strings.TrimSpace(cat); cat != "" {
+					todo.Categories = append(todo.Categories, cat)
+				}
+			}
+		case "due":
+			if !updateDueDate(todo, *due) {
+				errs = append(errs, fmt.Errorf("bad due date %q", *due))
+			}
+		case "start":
+			if !updateStartDate(todo, *start) {
+				errs = append(errs, fmt.Errorf("bad start date %q", *start))
+			}
+		case "rrule":
+			if *rrule != "" {
+				if _, err := parseRRule(*rrule); err != nil {
+					errs = append(errs, fmt.Errorf("bad repeat rule: %v", err))
+				}
+			}
+			todo.RRule = *rrule
+		}
+	})
+	if len(errs) > 0 {
+		fmt.Fprintf(stderr, "todocalmenu: %v\n", errors.Join(errs...))
+		return exitUsage
+	}
+	todo.LastMod = time.Now()
+	todo.Modified = true
+	fmt.Fprintf(stdout, "Updated: %s\n", todo.Summary)
+	return exitOK
+}
+
+func cmdRm(todoList *TodoList, args []string, stdout, stderr io.Writer) int {
+	todo, code := resolveTodoArg(todoList, args, false, stderr)
+	if todo == nil {
+		return code
+	}
+	// Subtasks move up a level rather than being deleted silently
+	if !deleteTodos([]*Todo{todo}, todoList)[todo.UID] {
+		fmt.Fprintf(stderr, "todocalmenu: could not delete %s\n", todo.Summary)
+		return exitError
+	}
+	fmt.Fprintf(stdout, "Deleted: %s\n", todo.Summary)
+	return exitOK
+}
+
+// cmdSplit splits the .ics files of every list into one file per UID.
+func cmdSplit(todoList *TodoList, args []string, stdout, stderr io.Writer) int {
+	fs := newFlagSet("split", stderr)
+	dryRun := fs.Bool("n", false, "Only show what would be split")
+	if err := fs.Parse(args); err != nil {
+		return exitUsage
+	}
+	code := exitOK
+	for _, c := range todoList.Collections {
+		files, err := filepath.Glob(filepath.Join(c.Dir, "*.ics"))
+		if err != nil {
+			fmt.Fprintf(stderr, "todocalmenu: %v\n", err)
+			return exitError
+		}
+		for _, file := range files {
+			names, err := splitFile(file, *dryRun)
+			if err != nil {
+				fmt.Fprintf(stderr, "todocalmenu: splitting %s: %v\n", file, err)
+				code = exitError
+				continue
+			}
+			if len(names) == 0 {
+				continue
+			}
+			verb := "Split"
+			if *dryRun {
+				verb = "Would split"
+			}
+			fmt.Fprintf(stdout, "%s %s into %s\n", verb, file, strings.Join(names, ", "))
+		}
+	}
+	return code
+}
+
+// resolveTodoArg finds the single todo matching the query in args, printing
+// an error and returning the exit code if there isn't exactly one.
+func resolveTodoArg(todoList *TodoList, args []string, openOnly bool, stderr io.Writer) (*Todo, int) {
+	if len(args) == 0 {
+		fmt.Fprintln(stderr, "todocalmenu: missing <uid|query>")
+		return nil, exitUsage
+	}
+	todo, matches, err := resolveTodo(todoList, strings.Join(args, " "), openOnly)
+	switch {
+	case errors.Is(err, errNotFound):
+		fmt.Fprintf(stderr, "todocalmenu: %v\n", err)
+		return nil, exitNotFound
+	case errors.Is(err, errAmbiguous):
+		fmt.Fprintf(stderr, "todocalmenu: %v:\n", err)
+		for _, t := range matches {
+			fmt.Fprintf(stderr, "%s\t%s\n", t.UID, t.Summary)
+		}
+		return nil, exitAmbiguous
+	}
+	return todo, exitOK
+}
+
+// resolveTodo returns the todo whose UID is query, or else the only todo
+// whose summary contains query ignoring case. With openOnly, completed and
+// cancelled todos are only matched by UID.
+func resolveTodo(todoList *TodoList, query string, openOnly bool) (*Todo, []*Todo, error) {
+	if todo := findTodo(todoList, query); todo != nil {
+		return todo, nil, nil
+	}
+	q := strings.ToLower(query)
+	var matches []*Todo
+	for _, todo := range todoList.Todos {
+		if openOnly && !isOpen(todo) {
+			continue
+		}
+		if strings.Contains(strings.ToLower(todo.Summary), q) {
+			matches = append(matches, todo)
+		}
+	}
+	switch len(matches) {
+	case 0:
+		return nil, nil, fmt.Errorf("%w for %q", errNotFound, query)
+	case 1:
+		return matches[0], matches, nil
+	}
+	return nil, matches, fmt.Errorf("%w for %q", errAmbiguous, query)
+}
+
+func writeJSON(stdout, stderr io.Writer, v any) int {
+	enc := json.NewEncoder(stdout)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(v); err != nil {
+		fmt.Fprintf(stderr, "todocalmenu: %v\n", err)
+		return exitError
+	}
+	return exitOK
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// copyTestdata copies the testdata calendars into a temporary directory.
+func copyTestdata(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+	files, err := filepath.Glob(filepath.Join("testdata", "*.ics"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatalf("Failed to read test file: %v", err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, filepath.Base(file)), data, 0644); err != nil {
+			t.Fatalf("Failed to write test file: %v", err)
+		}
+	}
+	return dir
+}
+
+func runTestCommand(t *testing.T, dir string, args ...string) (string, string, int) {
+	t.Helper()
+	old := *todoPtr
+	*todoPtr = dir
+	defer func() { *todoPtr = old }()
+	var stdout, stderr bytes.Buffer
+	code := runCommand(args, dir, &stdout, &stderr)
+	return stdout.String(), stderr.String(), code
+}
+
+func TestCommandAddAndShow(t *testing.T) {
+	dir := t.TempDir()
+	out, errOut, code := runTestCommand(t, dir, "add", "Pay rent @home !2 due:2030-01-31")
+	if code != exitOK {
+		t.Fatalf("add exited %d: %s", code, errOut)
+	}
+	uid := strings.TrimSpace(out)
+	if _, err := os.Stat(filepath.Join(dir, todoFileName(uid))); err != nil {
+		t.Fatalf("Expected file for new todo: %v", err)
+	}
+
+	out, errOut, code = runTestCommand(t, dir, "show", "-json", uid)
+	if code != exitOK {
+		t.Fatalf("show exited %d: %s", code, errOut)
+	}
+	var got todoJSON
+	if err := json.Unmarshal([]byte(out), &got); err != nil {
+		t.Fatalf("Invalid JSON %q: %v", out, err)
+	}
+	if got.Summary != "Pay rent" || got.Priority != 2 || got.Due != "2030-01-31" ||
+		len(got.Categories) != 1 || got.Categories[0] != "home" {
+		t.Errorf("Unexpected todo %+v", got)
+	}
+
+	if _, _, code := runTestCommand(t, dir, "add"); code != exitUsage {
+		t.Errorf("Expected usage error for add without a title, got %d", code)
+	}
+}
+
+func TestCommandList(t *testing.T) {
+	dir := copyTestdata(t)
+	out, _, code := runTestCommand(t, dir, "list")
+	if code != exitOK {
+		t.Fatalf("list exited %d", code)
+	}
+	lines := strings.Split(strings.TrimSpace(out), "\n")
+	for _, line := range lines {
+		uid, _, ok := strings.Cut(line, "\t")
+		if !ok || findTodoByUID(mustLoad(t, dir), uid) == nil {
+			t.Errorf("Expected UID<TAB>line, got %q", line)
+		}
+	}
+
+	out, _, _ = runTestCommand(t, dir, "list", "-json", "-all")
+	var all []todoJSON
+	if err := json.Unmarshal([]byte(out), &all); err != nil {
+		t.Fatalf("Invalid JSON: %v", err)
+	}
+	if len(all) != 6 {
+		t.Errorf("Expected 6 todos with -all, got %d", len(all))
+	}
+}
+
+func TestCommandDoneEditRm(t *testing.T) {
+	dir := copyTestdata(t)
+	if _, errOut, code := runTestCommand(t, dir, "done", "35rU"); code != exitOK {
+		t.Fatalf("done exited %d: %s", code, errOut)
+	}
+	if todo := findTodoByUID(mustLoad(t, dir), "35rU"); todo.Status != "COMPLETED" {
+		t.Errorf("Expected 35rU to be completed, got %s", todo.Status)
+	}
+
+	_, errOut, code := runTestCommand(t, dir, "edit", "-priority", "5", "-cat", "a, b", "-due", "", "sLNz")
+	if code != exitOK {
+		t.Fatalf("edit exited %d: %s", code, errOut)
+	}
+	todo := findTodoByUID(mustLoad(t, dir), "sLNz")
+	if todo.Priority != 5 || strings.Join(todo.Categories, ",") != "a,b" || !todo.DueDate.IsZero() {
+		t.Errorf("Unexpected edit result: priority %d, categories %v, due %v", todo.Priority, todo.Categories, todo.DueDate)
+	}
+	if _, _, code := runTestCommand(t, dir, "edit", "-due", "someday", "sLNz"); code != exitUsage {
+		t.Errorf("Expected usage error for a bad date, got %d", code)
+	}
+
+	if _, errOut, code := runTestCommand(t, dir, "rm", "sLNz"); code != exitOK {
+		t.Fatalf("rm exited %d: %s", code, errOut)
+	}
+	if findTodoByUID(mustLoad(t, dir), "sLNz") != nil {
+		t.Error("Expected sLNz to be deleted")
+	}
+}
+
+func TestCommandPrintsOnlyAfterSaving(t *testing.T) {
+	dir := t.TempDir()
+	// Saving is refused because the alarm has no TRIGGER
+	data := "BEGIN:VCALENDAR\r\nVERSION:2.0\r\nPRODID:x\r\nBEGIN:VTODO\r\nUID:bad\r\n" +
+		"DTSTAMP:20240101T000000Z\r\nSUMMARY:Broken\r\n" +
+		"BEGIN:VALARM\r\nACTION:AUDIO\r\nEND:VALARM\r\nEND:VTODO\r\nEND:VCALENDAR\r\n"
+	if err := os.WriteFile(filepath.Join(dir, "bad.ics"), []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	out, errOut, code := runTestCommand(t, dir, "edit", "-summary", "Fixed", "bad")
+	if code != exitError || out != "" || errOut == "" {
+		t.Errorf("Expected an error and no output, got %d %q %q", code, out, errOut)
+	}
+}
+
+func TestCommandExitCodes(t *testing.T) {
+	dir := copyTestdata(t)
+	tests := []struct {
+		args []string
+		code int
+	}{
+		{nil, exitUsage},
+		{[]string{"frobnicate"}, exitUsage},
+		{[]string{"show"}, exitUsage},
+		{[]string{"show", "no such todo"}, exitNotFound},
+		{[]string{"show", "test"}, exitAmbiguous},
+		{[]string{"show", "35rU"}, exitOK},
+	}
+	for _, tt := range tests {
+		if _, _, code := runTestCommand(t, dir, tt.args...); code != tt.code {
+			t.Errorf("%v exited %d, expected %d", tt.args, code, tt.code)
+		}
+	}
+}
+
+func mustLoad(t *testing.T, dir string) *TodoList {
+	t.Helper()
+	todoList, err := loadTodos(dir)
+	if err != nil {
+		t.Fatalf("Failed to load todos: %v", err)
+	}
+	return todoList
+}
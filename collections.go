@@ -0,0 +1,221 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Collection is a directory of .ics files holding one todo list, the way
+// vdirsyncer lays out each CalDAV list.
+type Collection struct {
+	Name string
+	Dir  string
+}
+
+// todoDirs splits the comma separated -todo value into directories.
+func todoDirs(spec string) []string {
+	var dirs []string
+	for _, dir := range strings.Split(spec, ",") {
+		if dir = strings.TrimSpace(dir); dir != "" {
+			dirs = append(dirs, expandHome(dir))
+		}
+	}
+	return dirs
+}
+
+// findCollections returns the collections in the comma separated -todo
+// directories. A directory holding .ics files, or no subdirectories, is a
+// collection itself. Otherwise it is a parent directory and each
+// subdirectory with .ics files or a vdirsyncer displayname file is one.
+func findCollections(spec string) ([]*Collection, error) {
+	var collections []*Collection
+	for _, dir := range todoDirs(spec) {
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			return nil, fmt.Errorf("error reading directory: %v", err)
+		}
+		var subdirs []string
+		isCollection := true
+		for _, e := range entries {
+			if filepath.Ext(e.Name()) == ".ics" {
+				isCollection = true
+				break
+			}
+			if e.IsDir() && !strings.HasPrefix(e.Name(), ".") && isCollectionDir(filepath.Join(dir, e.Name())) {
+				subdirs = append(subdirs, filepath.Join(dir, e.Name()))
+				isCollection = false
+			}
+		}
+		if isCollection {
+			collections = append(collections, &Collection{Name: collectionName(dir), Dir: dir})
+			continue
+		}
+		for _, sub := range subdirs {
+			collections = append(collections, &Collection{Name: collectionName(sub), Dir: sub})
+		}
+	}
+	if len(collections) == 0 {
+		return nil, fmt.Errorf("no todo directories given")
+	}
+	return collections, nil
+}
+
+func isCollectionDir(dir string) bool {
+	if _, err := os.Stat(filepath.Join(dir, "displayname")); err == nil {
+		return true
+	}
+	matches, _ := filepath.Glob(filepath.Join(dir, "*.ics"))
+	return len(matches) > 0
+}
+
+// collectionName is the vdirsyncer displayname of a collection, or the
+// directory name.
+func collectionName(dir string) string {
+	if data, err := os.ReadFile(filepath.Join(dir, "displayname")); err == nil {
+		if name := strings.TrimSpace(string(data)); name != "" {
+			return name
+		}
+	}
+	return filepath.Base(filepath.Clean(dir))
+}
+
+// loadCollections loads the todos of every collection, tagging each todo
+// with the collection it came from.
+func loadCollections(collections []*Collection) (*TodoList, error) {
+	todoList := &TodoList{Collections: collections}
+	for _, c := range collections {
+		list, err := loadTodos(c.Dir)
+		if err != nil {
+			return nil, err
+		}
+		for _, todo := range list.Todos {
+			todo.Collection = c
+		}
+		todoList.Todos = append(todoList.Todos, list.Todos...)
+	}
+	return todoList, nil
+}
+
+// findCollection returns the collection called name, ignoring case.
+func findCollection(todoList *TodoList, name string) *Collection {
+	for _, c := range todoList.Collections {
+		if strings.EqualFold(c.Name, name) {
+			return c
+		}
+	}
+	return nil
+}
+
+// todoDir returns the directory a todo is stored in: its collection's, or
+// the first -todo directory for a todo loaded on its own.
+func todoDir(todo *Todo) string {
+	if todo.Collection != nil {
+		return todo.Collection.Dir
+	}
+	if dirs := todoDirs(*todoPtr); len(dirs) > 0 {
+		return dirs[0]
+	}
+	return ""
+}
+
+// listName returns the name of the todo's collection, or "".
+func listName(todo *Todo) string {
+	if todo.Collection == nil {
+		return ""
+	}
+	return todo.Collection.Name
+}
+
+// inListFilter reports whether the todo is in one of the lists named in
+// -list, or whether no list filter is set.
+func inListFilter(todo *Todo) bool {
+	if *listFilterPtr == "" {
+		return true
+	}
+	for _, name := range strings.Split(*listFilterPtr, ",") {
+		if strings.EqualFold(strings.TrimSpace(name), listName(todo)) {
+			return true
+		}
+	}
+	return false
+}
+
+// chooseCollection returns the collection for a new todo: the parent's,
+// the only one shown by the list filter, or the one the user picks. It
+// returns errEscape if the user cancelled.
+func chooseCollection(todoList *TodoList, parentUID string) (*Collection, error) {
+	if parent := findTodo(todoList, parentUID); parent != nil && parent.Collection != nil {
+		return parent.Collection, nil
+	}
+	var candidates []*Collection
+	for _, c := range todoList.Collections {
+		if inListFilter(&Todo{Collection: c}) {
+			candidates = append(candidates, c)
+		}
+	}
+	if len(candidates) == 0 {
+		// The list filter matches none of them
+		candidates = todoList.Collections
+	}
+	switch len(candidates) {
+	case 0:
+		return nil, fmt.Errorf("no todo lists to add to")
+	case 1:
+		return candidates[0], nil
+	}
+	c, ok := pickCollection(candidates, "List:")
+	if !ok {
+		return nil, errEscape
+	}
+	return c, nil
+}
+
+func pickCollection(collections []*Collection, prompt string) (*Collection, bool) {
+	names := make([]string, len(collections))
+	for i, c := range collections {
+		names[i] = c.Name
+	}
+	out, err := menu.Show(strings.Join(names, "\n"), prompt)
+	if err != nil {
+		return nil, false
+	}
+	for _, c := range collections {
+		if c.Name == out {
+			return c, true
+		}
+	}
+	return nil, false
+}
+
+// moveToList lets the user move a todo to another collection. The old file
+// is removed once the todo is saved in the new one.
+func moveToList(todo *Todo, todoList *TodoList) {
+	c, ok := pickCollection(todoList.Collections, "Move to list:")
+	if !ok || c == todo.Collection {
+		return
+	}
+	if todo.FileName != "" && todo.MovedFrom == "" {
+		todo.MovedFrom = filepath.Join(todoDir(todo), todo.FileName)
+	}
+	todo.Collection = c
+	todo.Modified = true
+}
+
+// filterLists lets the user pick which list the main menu shows.
+func filterLists(todoList *TodoList) {
+	options := "All"
+	for _, c := range todoList.Collections {
+		options += "\n" + c.Name
+	}
+	out, err := menu.Show(options, "Show list:")
+	if err != nil {
+		return
+	}
+	if out == "All" {
+		*listFilterPtr = ""
+	} else if c := findCollection(todoList, out); c != nil {
+		*listFilterPtr = c.Name
+	}
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const listTestTodo = `BEGIN:VCALENDAR
+VERSION:2.0
+PRODID:-//test//EN
+BEGIN:VTODO
+UID:%s
+CREATED:20240918T131500Z
+SUMMARY:%s
+STATUS:NEEDS-ACTION
+END:VTODO
+END:VCALENDAR
+`
+
+// writeList creates a collection directory holding one todo, with a
+// vdirsyncer displayname if name isn't empty.
+func writeList(t *testing.T, dir, name, uid, summary string) {
+	t.Helper()
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if name != "" {
+		if err := os.WriteFile(filepath.Join(dir, "displayname"), []byte(name+"\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	data := fmt.Sprintf(listTestTodo, uid, summary)
+	if err := os.WriteFile(filepath.Join(dir, uid+".ics"), []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// listTestDir is a vdirsyncer style directory with a Work and a home list.
+func listTestDir(t *testing.T) string {
+	t.Helper()
+	root := t.TempDir()
+	writeList(t, filepath.Join(root, "abc123"), "Work", "w1", "Write report")
+	writeList(t, filepath.Join(root, "home"), "", "h1", "Mow lawn")
+	if err := os.MkdirAll(filepath.Join(root, ".git"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	return root
+}
+
+func loadListTestDir(t *testing.T, root string) *TodoList {
+	t.Helper()
+	collections, err := findCollections(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	todoList, err := loadCollections(collections)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return todoList
+}
+
+func TestFindCollections(t *testing.T) {
+	root := listTestDir(t)
+	collections, err := findCollections(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, c := range collections {
+		names = append(names, c.Name)
+	}
+	if strings.Join(names, ",") != "Work,home" {
+		t.Errorf("Expected lists Work,home, got %v", names)
+	}
+
+	// A directory of .ics files is a list itself, and several can be given
+	plain := filepath.Join(t.TempDir(), "errands")
+	writeList(t, plain, "", "e1", "Post letter")
+	collections, err = findCollections(root + "," + plain)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(collections) != 3 || collections[2].Name != "errands" || collections[2].Dir != plain {
+		t.Errorf("Unexpected collections %+v", collections)
+	}
+
+	// An empty directory is an empty list
+	empty := t.TempDir()
+	collections, err = findCollections(empty)
+	if err != nil || len(collections) != 1 || collections[0].Dir != empty {
+		t.Errorf("Expected the empty directory as a list, got %+v, %v", collections, err)
+	}
+}
+
+func TestListsInMenu(t *testing.T) {
+	todoList := loadListTestDir(t, listTestDir(t))
+	for _, todo := range todoList.Todos {
+		if todo.Collection == nil {
+			t.Fatalf("Expected %q to have a list", todo.Summary)
+		}
+	}
+
+	list := createMenu(todoList, openItems)
+	if !strings.Contains(list.String(), "Write report [Work]") || !strings.Contains(list.String(), "Lists: All") {
+		t.Errorf("Expected list names in the menu, got:\n%s", list)
+	}
+
+	defer func(old string) { *listFilterPtr = old }(*listFilterPtr)
+	useMenu(t, "home")
+	filterLists(todoList)
+	lines := createMenu(todoList, openItems).todoLines()
+	if len(lines) != 1 || !strings.Contains(lines[0], "Mow lawn") {
+		t.Errorf("Expected only the home list, got %q", lines)
+	}
+}
+
+func TestAddItemChoosesList(t *testing.T) {
+	todoList := loadListTestDir(t, listTestDir(t))
+	s := useMenu(t, "Buy paper", "Work")
+	addItem(todoList, "")
+	todo := todoList.Todos[len(todoList.Todos)-1]
+	if todo.Summary != "Buy paper" || listName(todo) != "Work" {
+		t.Errorf("Expected the new todo in Work, got %q in %q", todo.Summary, listName(todo))
+	}
+	if !slicesContainsPrefix(s.prompts, "List:") {
+		t.Errorf("Expected to be asked for a list, prompts were %q", s.prompts)
+	}
+
+	// Subtasks go in their parent's list without asking
+	s = useMenu(t, "Staple it")
+	addItem(todoList, todo.UID)
+	sub := todoList.Todos[len(todoList.Todos)-1]
+	if listName(sub) != "Work" || slicesContainsPrefix(s.prompts, "List:") {
+		t.Errorf("Expected the subtask in Work without a prompt, got %q, prompts %q", listName(sub), s.prompts)
+	}
+}
+
+func TestChooseCollection(t *testing.T) {
+	if c, err := chooseCollection(&TodoList{}, ""); c != nil || err == nil {
+		t.Errorf("Expected an error without lists, got %v", c)
+	}
+
+	// With the list filter hiding every list, all of them are offered
+	defer func(old string) { *listFilterPtr = old }(*listFilterPtr)
+	*listFilterPtr = "nowhere"
+	todoList := &TodoList{Collections: []*Collection{{Name: "Work"}, {Name: "home"}}}
+	useMenu(t, "home")
+	if c, err := chooseCollection(todoList, ""); err != nil || c != todoList.Collections[1] {
+		t.Errorf("Expected home, got %v, %v", c, err)
+	}
+	useMenu(t, menuEscape)
+	if _, err := chooseCollection(todoList, ""); err != errEscape {
+		t.Errorf("Expected errEscape when cancelled, got %v", err)
+	}
+}
+
+func TestTodoDirFallback(t *testing.T) {
+	defer func(old string) { *todoPtr = old }(*todoPtr)
+	*todoPtr = " work/todos , home"
+	if dir := todoDir(&Todo{}); dir != "work/todos" {
+		t.Errorf("Expected the first -todo directory, got %q", dir)
+	}
+}
+
+func TestMoveToList(t *testing.T) {
+	root := listTestDir(t)
+	todoList := loadListTestDir(t, root)
+	todo := findTodo(todoList, "w1")
+	useMenu(t, "Move to list: Work", "home", "Save item")
+	editItem(todo, todoList)
+	if err := saveTodos(todoList, root); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(root, "abc123", "w1.ics")); !os.IsNotExist(err) {
+		t.Errorf("Expected the old file to be removed, got %v", err)
+	}
+	todoList = loadListTestDir(t, root)
+	if moved := findTodo(todoList, "w1"); moved == nil || listName(moved) != "home" || moved.Summary != "Write report" {
+		t.Errorf("Expected the todo in home after reloading, got %+v", moved)
+	}
+}
+
+func TestCommandLists(t *testing.T) {
+	root := listTestDir(t)
+	_, errOut, code := runTestCommand(t, root, "add", "-list", "work", "Book travel")
+	if code != exitOK {
+		t.Fatalf("add exited %d: %s", code, errOut)
+	}
+	if _, _, code := runTestCommand(t, root, "add", "-list", "nope", "Lost"); code != exitUsage {
+		t.Errorf("Expected exit %d for an unknown list, got %d", exitUsage, code)
+	}
+
+	out, errOut, code := runTestCommand(t, root, "list", "-list", "Work")
+	if code != exitOK {
+		t.Fatalf("list exited %d: %s", code, errOut)
+	}
+	if !strings.Contains(out, "Book travel [Work]") || strings.Contains(out, "Mow lawn") {
+		t.Errorf("Unexpected list output:\n%s", out)
+	}
+}
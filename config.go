@@ -0,0 +1,168 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+)
+
+// The config file is TOML and sets the same options as the command line
+// flags, using the flag names as keys. Flags given on the command line win.
+//
+//	cmd = "rofi"
+//	opts = "-theme todocalmenu"
+//	todo = "~/todos"
+//	threshold = true
+//	default-priority = 5
+//	default-categories = ["inbox"]
+//
+//	[keys]
+//	"Alt+c" = "complete"
+//
+//	[[views]]
+//	name = "Today"
+//	filter = "due<=today"
+//
+// Views are described in views.go.
+
+// defaultConfigPath returns $XDG_CONFIG_HOME/todocalmenu/config.toml.
+func defaultConfigPath() string {
+	dir := os.Getenv("XDG_CONFIG_HOME")
+	if dir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return ""
+		}
+		dir = filepath.Join(home, ".config")
+	}
+	return filepath.Join(dir, "todocalmenu", "config.toml")
+}
+
+// parseTOML parses a config file. Tables are map[string]any and arrays of
+// tables []map[string]any.
+func parseTOML(data string) (map[string]any, error) {
+	cfg := make(map[string]any)
+	if _, err := toml.Decode(data, &cfg); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// loadConfig reads the config file at path, or the default one if path is
+// empty, and applies it to the flags that weren't set on the command line.
+// A missing default config file is not an error.
+func loadConfig(path string, flags *flag.FlagSet) error {
+	explicit := path != ""
+	if !explicit {
+		path = defaultConfigPath()
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) && !explicit {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	cfg, err := parseTOML(string(data))
+	if err != nil {
+		return fmt.Errorf("%s: %v", path, err)
+	}
+	if v, ok := cfg["views"]; ok {
+		if views, err = parseViews(v); err != nil {
+			return fmt.Errorf("%s: %v", path, err)
+		}
+		delete(cfg, "views")
+	}
+	if err := applyConfig(cfg, flags); err != nil {
+		return fmt.Errorf("%s: %v", path, err)
+	}
+	return nil
+}
+
+// applyConfig sets each flag named in cfg unless it was given on the
+// command line.
+func applyConfig(cfg map[string]any, flags *flag.FlagSet) error {
+	set := make(map[string]bool)
+	flags.Visit(func(f *flag.Flag) { set[f.Name] = true })
+	for _, key := range sortedConfigKeys(cfg) {
+		if flags.Lookup(key) == nil || key == "config" {
+			log.Printf("Unknown config setting %q", key)
+			continue
+		}
+		if set[key] {
+			continue
+		}
+		value, err := configString(cfg[key])
+		if err != nil {
+			return fmt.Errorf("%s: %v", key, err)
+		}
+		if err := flags.Set(key, value); err != nil {
+			return fmt.Errorf("%s: %v", key, err)
+		}
+	}
+	return nil
+}
+
+// configString converts a config value to the string form of its flag.
+// Arrays are comma separated and tables become key=value pairs.
+func configString(value any) (string, error) {
+	switch v := value.(type) {
+	case string:
+		return v, nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	case int64:
+		return strconv.FormatInt(v, 10), nil
+	case float64:
+		return strconv.FormatFloat(v, 'g', -1, 64), nil
+	case []any:
+		var parts []string
+		for _, item := range v {
+			s, err := configString(item)
+			if err != nil {
+				return "", err
+			}
+			parts = append(parts, s)
+		}
+		return strings.Join(parts, ","), nil
+	case map[string]any:
+		var parts []string
+		for _, key := range sortedConfigKeys(v) {
+			s, err := configString(v[key])
+			if err != nil {
+				return "", err
+			}
+			parts = append(parts, key+"="+s)
+		}
+		return strings.Join(parts, ","), nil
+	}
+	return "", fmt.Errorf("unsupported value %v", value)
+}
+
+func sortedConfigKeys(m map[string]any) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// expandHome replaces a leading ~/ with the home directory.
+func expandHome(path string) string {
+	if rest, ok := strings.CutPrefix(path, "~/"); ok {
+		if home, err := os.UserHomeDir(); err == nil {
+			return filepath.Join(home, rest)
+		}
+	}
+	return path
+}
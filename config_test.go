@@ -0,0 +1,156 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseTOML(t *testing.T) {
+	cfg, err := parseTOML(`# todocalmenu
+cmd = "rofi"   # launcher
+opts = '-theme C:\themes\todo'
+threshold = true
+default-priority = 5
+"quoted key" = "tab\there \u00e9"
+default-categories = [
+  "inbox", # first
+  "home",
+]
+
+notes = """
+two
+lines"""
+colors = { due = "red", done = 'grey' }
+tui.width.max = 80
+
+[keys]
+"Alt+c" = "complete"
+
+[[views]]
+name = "work"
+[[views]]
+name = "home"
+`)
+	if err != nil {
+		t.Fatalf("parseTOML: %v", err)
+	}
+	expected := map[string]any{
+		"cmd":                "rofi",
+		"opts":               `-theme C:\themes\todo`,
+		"threshold":          true,
+		"default-priority":   int64(5),
+		"quoted key":         "tab\there é",
+		"default-categories": []any{"inbox", "home"},
+		"notes":              "two\nlines",
+		"colors":             map[string]any{"due": "red", "done": "grey"},
+		"tui":                map[string]any{"width": map[string]any{"max": int64(80)}},
+		"keys":               map[string]any{"Alt+c": "complete"},
+		"views":              []map[string]any{{"name": "work"}, {"name": "home"}},
+	}
+	if !reflect.DeepEqual(cfg, expected) {
+		t.Errorf("Expected %v, got %v", expected, cfg)
+	}
+}
+
+func TestParseTOMLErrors(t *testing.T) {
+	for _, test := range []struct {
+		input, line string
+	}{
+		{`cmd = "rofi`, "line 1"},
+		{`cmd "rofi"`, "line 1"},
+		{"# launcher\ncmd = rofi", "line 2"},
+		{"cmd = \"a\"\ncmd = \"b\"", "line 2"},
+		{"list = [1, 2", "line 1"},
+		{`[keys`, "line 1"},
+		{"[keys]\n\n[keys]", "line 3"},
+		{`cmd = "a" b`, "line 1"},
+		{`s = "\q"`, "line 1"},
+		{"a = 2\na.b = 1", "line 2"},
+	} {
+		_, err := parseTOML(test.input)
+		if err == nil || !strings.Contains(err.Error(), test.line) {
+			t.Errorf("Expected an error on %s for %q, got %v", test.line, test.input, err)
+		}
+	}
+}
+
+func TestLoadConfigFlagsWin(t *testing.T) {
+	flags := flag.NewFlagSet("test", flag.ContinueOnError)
+	cmd := flags.String("cmd", "dmenu", "")
+	opts := flags.String("opts", "", "")
+	threshold := flags.Bool("threshold", false, "")
+	interval := flags.Duration("notify-interval", time.Minute, "")
+	cats := flags.String("default-categories", "", "")
+	keys := flags.String("keys", "", "")
+	if err := flags.Parse([]string{"-cmd", "fzf"}); err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(t.TempDir(), "config.toml")
+	config := `cmd = "rofi"
+opts = "-theme todo"
+threshold = true
+notify-interval = "5m"
+default-categories = ["inbox", "home"]
+unknown = 1
+
+[keys]
+"Alt+d" = "delete"
+"Alt+c" = "complete"
+`
+	if err := os.WriteFile(path, []byte(config), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := loadConfig(path, flags); err != nil {
+		t.Fatalf("loadConfig: %v", err)
+	}
+	if *cmd != "fzf" {
+		t.Errorf("Expected the -cmd flag to win, got %q", *cmd)
+	}
+	if *opts != "-theme todo" || !*threshold || *interval != 5*time.Minute || *cats != "inbox,home" {
+		t.Errorf("Unexpected settings: opts %q threshold %v interval %v categories %q", *opts, *threshold, *interval, *cats)
+	}
+	if *keys != "Alt+c=complete,Alt+d=delete" {
+		t.Errorf("Unexpected keys %q", *keys)
+	}
+
+	if err := os.WriteFile(path, []byte("threshold = \"maybe\""), 0644); err != nil {
+		t.Fatal(err)
+	}
+	flags = flag.NewFlagSet("test", flag.ContinueOnError)
+	flags.Bool("threshold", false, "")
+	if err := loadConfig(path, flags); err == nil {
+		t.Error("Expected an error for a bad value")
+	}
+}
+
+func TestLoadConfigMissing(t *testing.T) {
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	flags := flag.NewFlagSet("test", flag.ContinueOnError)
+	if err := loadConfig("", flags); err != nil {
+		t.Errorf("Expected a missing default config to be ignored, got %v", err)
+	}
+	if err := loadConfig(filepath.Join(t.TempDir(), "nope.toml"), flags); err == nil {
+		t.Error("Expected an error for a missing -config file")
+	}
+	if dir := os.Getenv("XDG_CONFIG_HOME"); defaultConfigPath() != filepath.Join(dir, "todocalmenu", "config.toml") {
+		t.Errorf("Unexpected default path %q", defaultConfigPath())
+	}
+}
+
+func TestNewTodoDefaults(t *testing.T) {
+	oldPriority, oldCats := *defaultPriorityPtr, *defaultCategoriesPtr
+	defer func() { *defaultPriorityPtr, *defaultCategoriesPtr = oldPriority, oldCats }()
+	*defaultPriorityPtr, *defaultCategoriesPtr = 5, "inbox, home"
+
+	todo := newTodo("")
+	applyQuickAdd(todo, "Pay rent !2 @bills", time.Now())
+	if todo.Priority != 2 || !reflect.DeepEqual(todo.Categories, []string{"inbox", "home", "bills"}) {
+		t.Errorf("Unexpected todo: priority %d categories %v", todo.Priority, todo.Categories)
+	}
+}
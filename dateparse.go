@@ -0,0 +1,146 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Shortcuts offered in the date prompts
+var dateShortcuts = []string{
+	"today",
+	"tomorrow",
+	"next monday",
+	"fri",
+	"+3d",
+	"+1w",
+	"end of month",
+	"in 2 hours",
+}
+
+var weekdayNames = map[string]time.Weekday{
+	"sun": time.Sunday, "sunday": time.Sunday,
+	"mon": time.Monday, "monday": time.Monday,
+	"tue": time.Tuesday, "tues": time.Tuesday, "tuesday": time.Tuesday,
+	"wed": time.Wednesday, "wednesday": time.Wednesday,
+	"thu": time.Thursday, "thur": time.Thursday, "thurs": time.Thursday, "thursday": time.Thursday,
+	"fri": time.Friday, "friday": time.Friday,
+	"sat": time.Saturday, "saturday": time.Saturday,
+}
+
+// parseDateInput parses absolute, relative and natural language dates
+// relative to now, for example "2024-09-18 14:30", "tomorrow", "fri",
+// "next monday", "+3d", "+2w", "end of month" or "in 2 hours". A trailing
+// time of day ("fri 9:00") is allowed. hasTime reports whether the result
+// includes a time of day; otherwise it is midnight local time.
+func parseDateInput(input string, now time.Time) (t time.Time, hasTime bool, err error) {
+	s := strings.ToLower(strings.Join(strings.Fields(input), " "))
+	if s == "" {
+		return time.Time{}, false, fmt.Errorf("empty date")
+	}
+	now = now.Local()
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.Local)
+
+	// "in N units" and "+N unit" style offsets
+	if rest, ok := strings.CutPrefix(s, "in "); ok {
+		return parseOffset(rest, now, today)
+	}
+	if strings.HasPrefix(s, "+") || strings.HasPrefix(s, "-") {
+		return parseOffset(s, now, today)
+	}
+
+	// Split off a trailing time of day
+	var hour, min int
+	if i := strings.LastIndex(s, " "); i > 0 {
+		if h, m, ok := parseTimeOfDay(s[i+1:]); ok {
+			hour, min, hasTime = h, m, true
+			s = s[:i]
+		}
+	} else if h, m, ok := parseTimeOfDay(s); ok {
+		// A bare time means today
+		return time.Date(today.Year(), today.Month(), today.Day(), h, m, 0, 0, time.Local), true, nil
+	}
+
+	var day time.Time
+	switch s {
+	case "today", "tod":
+		day = today
+	case "tomorrow", "tom":
+		day = today.AddDate(0, 0, 1)
+	case "yesterday":
+		day = today.AddDate(0, 0, -1)
+	case "next week":
+		day = today.AddDate(0, 0, 7)
+	case "next month":
+		day = today.AddDate(0, 1, 0)
+	case "next year":
+		day = today.AddDate(1, 0, 0)
+	case "end of week", "eow":
+		day = today.AddDate(0, 0, (7-int(today.Weekday()))%7)
+	case "end of month", "eom":
+		day = time.Date(today.Year(), today.Month()+1, 0, 0, 0, 0, 0, time.Local)
+	case "end of year", "eoy":
+		day = time.Date(today.Year(), 12, 31, 0, 0, 0, 0, time.Local)
+	default:
+		if wd, ok := weekdayNames[strings.TrimPrefix(s, "next ")]; ok {
+			// A bare weekday is the next one on or after today, "next"
+			// skips today
+			days := (int(wd) - int(today.Weekday()) + 7) % 7
+			if days == 0 && strings.HasPrefix(s, "next ") {
+				days = 7
+			}
+			day = today.AddDate(0, 0, days)
+		} else if d, err := time.ParseInLocation("2006-01-02", s, time.Local); err == nil {
+			day = d
+		} else {
+			return time.Time{}, false, fmt.Errorf("unrecognized date %q", input)
+		}
+	}
+	return time.Date(day.Year(), day.Month(), day.Day(), hour, min, 0, 0, time.Local), hasTime, nil
+}
+
+// parseTimeOfDay accepts hh:mm or a four digit hhmm.
+func parseTimeOfDay(s string) (hour, min int, ok bool) {
+	if !strings.Contains(s, ":") && len(s) != 4 {
+		return 0, 0, false
+	}
+	return parseClock(s)
+}
+
+// parseOffset parses "3d", "+2w", "-1m", "2 hours" or "30 minutes".
+func parseOffset(s string, now, today time.Time) (time.Time, bool, error) {
+	sign := 1
+	switch {
+	case strings.HasPrefix(s, "+"):
+		s = s[1:]
+	case strings.HasPrefix(s, "-"):
+		sign = -1
+		s = s[1:]
+	}
+	s = strings.ReplaceAll(s, " ", "")
+	i := 0
+	for i < len(s) && s[i] >= '0' && s[i] <= '9' {
+		i++
+	}
+	n, err := strconv.Atoi(s[:i])
+	if err != nil {
+		return time.Time{}, false, fmt.Errorf("invalid offset %q", s)
+	}
+	n *= sign
+	switch strings.TrimSuffix(s[i:], "s") {
+	case "min", "minute":
+		return now.Add(time.Duration(n) * time.Minute).Truncate(time.Minute), true, nil
+	case "h", "hr", "hour":
+		return now.Add(time.Duration(n) * time.Hour).Truncate(time.Minute), true, nil
+	case "d", "day":
+		return today.AddDate(0, 0, n), false, nil
+	case "w", "wk", "week":
+		return today.AddDate(0, 0, 7*n), false, nil
+	case "m", "mo", "month":
+		return today.AddDate(0, n, 0), false, nil
+	case "y", "yr", "year":
+		return today.AddDate(n, 0, 0), false, nil
+	}
+	return time.Time{}, false, fmt.Errorf("invalid offset unit %q", s[i:])
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseDateInput(t *testing.T) {
+	// Wednesday
+	now := time.Date(2024, 9, 18, 10, 15, 0, 0, time.Local)
+	day := func(m time.Month, d int) time.Time {
+		return time.Date(2024, m, d, 0, 0, 0, 0, time.Local)
+	}
+	tests := []struct {
+		input    string
+		expected time.Time
+		hasTime  bool
+	}{
+		{"today", day(9, 18), false},
+		{"Tomorrow", day(9, 19), false},
+		{"fri", day(9, 20), false},
+		{"wed", day(9, 18), false},
+		{"next wed", day(9, 25), false},
+		{"next monday", day(9, 23), false},
+		{"+3d", day(9, 21), false},
+		{"+2w", day(10, 2), false},
+		{"-1d", day(9, 17), false},
+		{"+1m", day(10, 18), false},
+		{"end of month", day(9, 30), false},
+		{"eow", day(9, 22), false},
+		{"in 2 hours", time.Date(2024, 9, 18, 12, 15, 0, 0, time.Local), true},
+		{"in 30 minutes", time.Date(2024, 9, 18, 10, 45, 0, 0, time.Local), true},
+		{"in 3 days", day(9, 21), false},
+		{"2024-10-01", day(10, 1), false},
+		{"2024-10-01 14:30", time.Date(2024, 10, 1, 14, 30, 0, 0, time.Local), true},
+		{"fri 9:00", time.Date(2024, 9, 20, 9, 0, 0, 0, time.Local), true},
+		{"16:00", time.Date(2024, 9, 18, 16, 0, 0, 0, time.Local), true},
+	}
+	for _, tt := range tests {
+		got, hasTime, err := parseDateInput(tt.input, now)
+		if err != nil {
+			t.Errorf("parseDateInput(%q) failed: %v", tt.input, err)
+			continue
+		}
+		if !got.Equal(tt.expected) || hasTime != tt.hasTime {
+			t.Errorf("parseDateInput(%q) = %v, %v; expected %v, %v", tt.input, got, hasTime, tt.expected, tt.hasTime)
+		}
+	}
+
+	for _, input := range []string{"", "someday", "+3x", "2024-13-01", "in a while"} {
+		if _, _, err := parseDateInput(input, now); err == nil {
+			t.Errorf("Expected error for %q", input)
+		}
+	}
+}
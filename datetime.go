@@ -0,0 +1,177 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	ics "github.com/arran4/golang-ical"
+)
+
+// DateKind is the form a DTSTART or DUE value was written in, so it can be
+// written back the same way.
+type DateKind int
+
+const (
+	DateTimeUTC      DateKind = iota // 20240918T200000Z
+	DateOnly                         // DUE;VALUE=DATE:20240918
+	DateTimeFloating                 // 20240918T160000, local wherever it is read
+	DateTimeZoned                    // DTSTART;TZID=America/New_York:20240918T160000
+)
+
+// isDateValue reports whether a property holds a date without a time.
+func isDateValue(value string, params map[string][]string) bool {
+	if v := params[string(ics.ParameterValue)]; len(v) > 0 {
+		return strings.EqualFold(v[0], "DATE")
+	}
+	return len(value) == 8
+}
+
+// parseDateProperty parses a DTSTART, DUE, RDATE or EXDATE style property
+// value and reports its kind and TZID. Times are returned in local time.
+func parseDateProperty(value string, params map[string][]string) (time.Time, DateKind, string) {
+	if isDateValue(value, params) {
+		return parseDateTime(value), DateOnly, ""
+	}
+	if strings.HasSuffix(value, "Z") {
+		return parseDateTime(value), DateTimeUTC, ""
+	}
+	tzid := ""
+	if v := params[string(ics.ParameterTzid)]; len(v) > 0 {
+		tzid = v[0]
+	}
+	if tzid == "" {
+		return parseDateTime(value), DateTimeFloating, ""
+	}
+	loc, err := time.LoadLocation(tzid)
+	if err != nil {
+		// Keep the TZID so it is written back, but read the time as local
+		log.Printf("Unknown time zone %s: %v", tzid, err)
+		return parseDateTime(value), DateTimeZoned, tzid
+	}
+	t, err := time.ParseInLocation("20060102T150405", value, loc)
+	if err != nil {
+		log.Printf("Error parsing date-time: %v", err)
+		return time.Time{}, DateTimeZoned, tzid
+	}
+	return t.Local(), DateTimeZoned, tzid
+}
+
+// formatDateProperty is the inverse of parseDateProperty. It returns the
+// property value and parameters for t written as kind.
+func formatDateProperty(t time.Time, kind DateKind, tzid string) (string, []ics.PropertyParameter) {
+	switch kind {
+	case DateOnly:
+		return t.Local().Format("20060102"), []ics.PropertyParameter{ics.WithValue("DATE")}
+	case DateTimeFloating:
+		return t.Local().Format("20060102T150405"), nil
+	case DateTimeZoned:
+		if loc, err := time.LoadLocation(tzid); err == nil {
+			t = t.In(loc)
+		} else {
+			t = t.Local()
+		}
+		return t.Format("20060102T150405"), []ics.PropertyParameter{
+			&ics.KeyValues{Key: string(ics.ParameterTzid), Value: []string{tzid}},
+		}
+	}
+	return t.UTC().Format("20060102T150405Z"), nil
+}
+
+// setDateProperty writes a date property in the given form, removing it if
+// t is zero. A VTIMEZONE is added to the calendar for zoned times.
+func setDateProperty(cal *ics.Calendar, vtodo *ics.VTodo, property ics.ComponentProperty, t time.Time, kind DateKind, tzid string) {
+	if t.IsZero() {
+		removeProperty(vtodo, property)
+		return
+	}
+	// Leave the property as it was written if the time is the same
+	unchanged := false
+	if prop := vtodo.GetProperty(property); prop != nil {
+		old, oldKind, oldTZID := parseDateProperty(prop.Value, prop.ICalParameters)
+		unchanged = old.Equal(t) && oldKind == kind && oldTZID == tzid
+	}
+	if !unchanged {
+		value, params := formatDateProperty(t, kind, tzid)
+		vtodo.SetProperty(property, value, params...)
+	}
+	if kind == DateTimeZoned {
+		ensureTimezone(cal, tzid, t)
+	}
+}
+
+// ensureTimezone adds a VTIMEZONE for tzid to the calendar unless one is
+// already there. The definition is generated from the Go time zone database
+// and lists the offset transitions around t.
+func ensureTimezone(cal *ics.Calendar, tzid string, t time.Time) {
+	for _, tz := range cal.Timezones() {
+		if p := tz.GetProperty(ics.ComponentPropertyTzid); p != nil && p.Value == tzid {
+			return
+		}
+	}
+	loc, err := time.LoadLocation(tzid)
+	if err != nil {
+		return
+	}
+	cal.AddVTimezone(newVTimezone(tzid, loc, t))
+}
+
+func newVTimezone(tzid string, loc *time.Location, t time.Time) *ics.VTimezone {
+	tz := ics.NewTimezone(tzid)
+	start := time.Date(t.Year()-1, 1, 1, 0, 0, 0, 0, loc)
+	end := time.Date(t.Year()+2, 1, 1, 0, 0, 0, 0, loc)
+
+	name, offset := start.Zone()
+	_, initialFrom := start.Add(-time.Second).Zone()
+	tz.Components = append(tz.Components, timezoneObservance(start, name, initialFrom, offset, start.IsDST()))
+
+	// Walk day by day and narrow down to the exact second of each change
+	for day := start; day.Before(end); {
+		next := day.Add(24 * time.Hour)
+		if _, o := next.Zone(); o != offset {
+			lo, hi := day, next
+			for hi.Sub(lo) > time.Second {
+				mid := lo.Add(hi.Sub(lo) / 2)
+				if _, o := mid.Zone(); o == offset {
+					lo = mid
+				} else {
+					hi = mid
+				}
+			}
+			newName, newOffset := hi.Zone()
+			tz.Components = append(tz.Components, timezoneObservance(hi, newName, offset, newOffset, hi.IsDST()))
+			offset = newOffset
+		}
+		day = next
+	}
+	return tz
+}
+
+// timezoneObservance returns a STANDARD or DAYLIGHT component starting at
+// the transition instant at. DTSTART is the local time before the change.
+func timezoneObservance(at time.Time, name string, from, to int, dst bool) ics.Component {
+	base := ics.ComponentBase{}
+	wall := at.UTC().Add(time.Duration(from) * time.Second)
+	base.AddProperty(ics.ComponentPropertyDtStart, wall.Format("20060102T150405"))
+	base.AddProperty(ics.ComponentProperty(ics.PropertyTzoffsetfrom), formatUTCOffset(from))
+	base.AddProperty(ics.ComponentProperty(ics.PropertyTzoffsetto), formatUTCOffset(to))
+	base.AddProperty(ics.ComponentProperty(ics.PropertyTzname), name)
+	if dst {
+		return &ics.Daylight{ComponentBase: base}
+	}
+	return &ics.Standard{ComponentBase: base}
+}
+
+func formatUTCOffset(seconds int) string {
+	sign := "+"
+	if seconds < 0 {
+		sign = "-"
+		seconds = -seconds
+	}
+	s := fmt.Sprintf("%s%02d%02d", sign, seconds/3600, seconds%3600/60)
+	if seconds%60 != 0 {
+		s += fmt.Sprintf("%02d", seconds%60)
+	}
+	return s
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseDateProperty(t *testing.T) {
+	tests := []struct {
+		value    string
+		params   map[string][]string
+		kind     DateKind
+		tzid     string
+		expected time.Time
+	}{
+		{"20240918T200000Z", nil, DateTimeUTC, "", time.Date(2024, 9, 18, 20, 0, 0, 0, time.UTC)},
+		{"20240918", map[string][]string{"VALUE": {"DATE"}}, DateOnly, "", time.Date(2024, 9, 18, 0, 0, 0, 0, time.Local)},
+		{"20240918T160000", nil, DateTimeFloating, "", time.Date(2024, 9, 18, 16, 0, 0, 0, time.Local)},
+		{"20240918T160000", map[string][]string{"TZID": {"America/New_York"}}, DateTimeZoned, "America/New_York",
+			time.Date(2024, 9, 18, 20, 0, 0, 0, time.UTC)},
+	}
+	for _, tt := range tests {
+		got, kind, tzid := parseDateProperty(tt.value, tt.params)
+		if !got.Equal(tt.expected) || kind != tt.kind || tzid != tt.tzid {
+			t.Errorf("parseDateProperty(%q, %v) = %v, %v, %q; expected %v, %v, %q",
+				tt.value, tt.params, got, kind, tzid, tt.expected, tt.kind, tt.tzid)
+		}
+		value, _ := formatDateProperty(got, kind, tzid)
+		if value != tt.value {
+			t.Errorf("formatDateProperty round trip of %q gave %q", tt.value, value)
+		}
+	}
+}
+
+func TestSaveZonedStart(t *testing.T) {
+	tempDir := t.TempDir()
+	data, err := os.ReadFile(filepath.Join("testdata", "nmo5.ics"))
+	if err != nil {
+		t.Fatalf("Failed to read test file: %v", err)
+	}
+	filePath := filepath.Join(tempDir, "nmo5.ics")
+	if err := os.WriteFile(filePath, data, 0644); err != nil {
+		t.Fatalf("Failed to write test file: %v", err)
+	}
+
+	todoList, err := loadTodos(tempDir)
+	if err != nil {
+		t.Fatalf("Failed to load todos: %v", err)
+	}
+	todo := todoList.Todos[0]
+	if todo.StartKind != DateTimeZoned || todo.StartTZID != "America/New_York" {
+		t.Fatalf("Expected zoned start, got kind %v tzid %q", todo.StartKind, todo.StartTZID)
+	}
+	todo.Summary = "Edited"
+	todo.Modified = true
+	if err := saveTodos(todoList, tempDir); err != nil {
+		t.Fatalf("Failed to save todos: %v", err)
+	}
+
+	saved, err := os.ReadFile(filePath)
+	if err != nil {
+		t.Fatalf("Failed to read saved file: %v", err)
+	}
+	for _, expected := range []string{
+		"DTSTART;TZID=America/New_York:20240918T160000\r\n",
+		"BEGIN:VTIMEZONE\r\nTZID:America/New_York\r\n",
+		"BEGIN:DAYLIGHT\r\nDTSTART:20240310T020000\r\nTZOFFSETFROM:-0500\r\nTZOFFSETTO:-0400\r\n",
+	} {
+		if !strings.Contains(string(saved), expected) {
+			t.Errorf("Expected saved file to contain %q, got:\n%s", expected, saved)
+		}
+	}
+}
+
+func TestEnsureTimezoneKeepsExisting(t *testing.T) {
+	cal, err := loadICSFile(filepath.Join("testdata", "657913900676334277.ics"))
+	if err != nil {
+		t.Fatalf("Failed to load test file: %v", err)
+	}
+	before := len(cal.Components)
+	ensureTimezone(cal, "America/Los_Angeles", time.Now())
+	if len(cal.Components) != before {
+		t.Error("Expected existing VTIMEZONE to be reused")
+	}
+}
@@ -0,0 +1,291 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// A filter query is a list of terms that must all match, for example
+//
+//	cat:work due<7d pri<=3 status:in-process -cat:someday text:"invoice"
+//
+// A term is field, operator and value. The operator is : (or =) for fields
+// that are matched by name and one of : = < <= > >= for priorities and
+// dates. A leading - negates a term and values with spaces are quoted.
+// Words without one of the fields below, like "Re:" or a URL, search the
+// summary and description.
+//
+// Fields:
+//
+//	cat, list, status, uid     name, ignoring case; "open" status means
+//	                           not completed or cancelled
+//	text                       summary or description contains the value
+//	pri                        priority 1-9, "none" for unset
+//	due, start, created,       a date as the date prompts take it
+//	modified                   (2024-10-01, today, fri) or an offset from
+//	                           today (7d, -2w); "none" for unset
+//
+// Dates are compared by day, and items without a priority or date never
+// match a comparison.
+
+// todoFilter is a parsed filter query.
+type todoFilter struct {
+	query string
+	terms []filterTerm
+}
+
+type filterTerm struct {
+	field  string
+	op     string
+	value  string
+	negate bool
+}
+
+// filterDates gets the date fields of a todo.
+var filterDates = map[string]func(todo *Todo) time.Time{
+	"due":      func(todo *Todo) time.Time { return todo.DueDate },
+	"start":    func(todo *Todo) time.Time { return todo.StartDate },
+	"created":  func(todo *Todo) time.Time { return todo.Created },
+	"modified": func(todo *Todo) time.Time { return todo.LastMod },
+}
+
+// filterAliases are other names accepted for fields.
+var filterAliases = map[string]string{
+	"category":      "cat",
+	"priority":      "pri",
+	"threshold":     "start",
+	"last-modified": "modified",
+}
+
+// activeFilter is the filter applied to the lists, nil for none.
+var activeFilter *todoFilter
+
+// setFilter sets the filter applied to the lists. An empty query clears it.
+func setFilter(query string) error {
+	if strings.TrimSpace(query) == "" {
+		activeFilter = nil
+		return nil
+	}
+	f, err := parseFilter(query)
+	if err != nil {
+		return err
+	}
+	activeFilter = f
+	return nil
+}
+
+// filterQuery returns the query of the active filter, or "".
+func filterQuery() string {
+	if activeFilter == nil {
+		return ""
+	}
+	return activeFilter.query
+}
+
+// editFilter asks for a new filter query for the main list. An empty query
+// clears the filter.
+func editFilter() {
+	for {
+		q, err := menu.Prompt("Filter (cat:work due<7d pri<=3 status:open -cat:someday text:\"…\"):", filterQuery())
+		if err != nil {
+			return
+		}
+		if err := setFilter(q); err != nil {
+			menu.Show("", fmt.Sprintf("Bad filter: %v", err))
+			continue
+		}
+		return
+	}
+}
+
+// parseFilter parses a filter query.
+func parseFilter(query string) (*todoFilter, error) {
+	words, err := splitQuery(query)
+	if err != nil {
+		return nil, err
+	}
+	f := &todoFilter{query: strings.TrimSpace(query)}
+	now := time.Now()
+	for _, word := range words {
+		term, err := parseTerm(word)
+		if err != nil {
+			return nil, err
+		}
+		// Check the value now so mistakes show up before matching
+		if _, ok := filterDates[term.field]; ok && term.value != "none" {
+			if _, err := filterDate(term.value, now); err != nil {
+				return nil, fmt.Errorf("%s: %v", word, err)
+			}
+		}
+		if term.field == "pri" && term.value != "none" {
+			if _, err := strconv.Atoi(term.value); err != nil {
+				return nil, fmt.Errorf("%s: priority must be a number", word)
+			}
+		}
+		f.terms = append(f.terms, term)
+	}
+	return f, nil
+}
+
+// splitQuery splits a query at spaces outside double quotes. The quotes are
+// removed.
+func splitQuery(query string) ([]string, error) {
+	var words []string
+	var word strings.Builder
+	inWord, quoted := false, false
+	for _, r := range query {
+		switch {
+		case r == '"':
+			quoted = !quoted
+			inWord = true
+		case unicode.IsSpace(r) && !quoted:
+			if inWord {
+				words = append(words, word.String())
+				word.Reset()
+				inWord = false
+			}
+		default:
+			word.WriteRune(r)
+			inWord = true
+		}
+	}
+	if quoted {
+		return nil, fmt.Errorf("unterminated quote in %q", query)
+	}
+	if inWord {
+		words = append(words, word.String())
+	}
+	return words, nil
+}
+
+func parseTerm(word string) (filterTerm, error) {
+	var term filterTerm
+	if rest, ok := strings.CutPrefix(word, "-"); ok && rest != "" {
+		term.negate, word = true, rest
+	}
+	i := strings.IndexAny(word, ":=<>")
+	if i <= 0 {
+		term.field, term.op, term.value = "text", ":", word
+		return term, nil
+	}
+	term.field = strings.ToLower(word[:i])
+	if alias, ok := filterAliases[term.field]; ok {
+		term.field = alias
+	}
+	rest := word[i:]
+	for _, op := range []string{"<=", ">=", ":", "=", "<", ">"} {
+		if strings.HasPrefix(rest, op) {
+			term.op, term.value = op, rest[len(op):]
+			break
+		}
+	}
+	if term.op == "=" {
+		term.op = ":"
+	}
+	switch term.field {
+	case "cat", "list", "status", "uid", "text":
+		if term.op != ":" {
+			return term, fmt.Errorf("%s: %s can only be matched with :", word, term.field)
+		}
+	case "pri", "due", "start", "created", "modified":
+		if term.value == "" {
+			return term, fmt.Errorf("%s: missing value", word)
+		}
+	default:
+		term.field, term.op, term.value = "text", ":", word
+	}
+	return term, nil
+}
+
+// hasField reports whether the filter has a term for field.
+func (f *todoFilter) hasField(field string) bool {
+	for _, term := range f.terms {
+		if term.field == field {
+			return true
+		}
+	}
+	return false
+}
+
+// Match reports whether the todo matches every term.
+func (f *todoFilter) Match(todo *Todo, now time.Time) bool {
+	for _, term := range f.terms {
+		if term.match(todo, now) == term.negate {
+			return false
+		}
+	}
+	return true
+}
+
+func (t filterTerm) match(todo *Todo, now time.Time) bool {
+	switch t.field {
+	case "text":
+		v := strings.ToLower(t.value)
+		return strings.Contains(strings.ToLower(todo.Summary), v) ||
+			strings.Contains(strings.ToLower(todo.Description), v)
+	case "cat":
+		if t.value == "" {
+			return len(todo.Categories) == 0
+		}
+		for _, cat := range todo.Categories {
+			if strings.EqualFold(cat, t.value) {
+				return true
+			}
+		}
+		return false
+	case "list":
+		return strings.EqualFold(listName(todo), t.value)
+	case "uid":
+		return todo.UID == t.value
+	case "status":
+		if strings.EqualFold(t.value, "open") {
+			return todo.Status != "COMPLETED" && todo.Status != "CANCELLED"
+		}
+		return strings.EqualFold(todo.Status, t.value)
+	case "pri":
+		if t.value == "none" {
+			return todo.Priority == 0
+		}
+		n, _ := strconv.Atoi(t.value)
+		return todo.Priority != 0 && compareOp(t.op, todo.Priority-n)
+	}
+
+	date := filterDates[t.field](todo)
+	if t.value == "none" {
+		return date.IsZero()
+	}
+	target, err := filterDate(t.value, now)
+	if date.IsZero() || err != nil {
+		return false
+	}
+	return compareOp(t.op, daysUntil(date, now)-daysUntil(target, now))
+}
+
+// filterDate parses a date value of a filter term. Bare offsets like 7d
+// count from today.
+func filterDate(value string, now time.Time) (time.Time, error) {
+	value = strings.ReplaceAll(value, "_", " ")
+	if value != "" && unicode.IsDigit(rune(value[0])) && unicode.IsLetter(rune(value[len(value)-1])) {
+		value = "+" + value
+	}
+	t, _, err := parseDateInput(value, now)
+	return t, err
+}
+
+// compareOp applies a comparison operator to the sign of c.
+func compareOp(op string, c int) bool {
+	switch op {
+	case "<":
+		return c < 0
+	case "<=":
+		return c <= 0
+	case ">":
+		return c > 0
+	case ">=":
+		return c >= 0
+	}
+	return c == 0
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSplitQuery(t *testing.T) {
+	words, err := splitQuery(`cat:work  text:"send invoice" -"two words"`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"cat:work", "text:send invoice", "-two words"}
+	if strings.Join(words, "|") != strings.Join(want, "|") {
+		t.Errorf("Got %q, want %q", words, want)
+	}
+	if _, err := splitQuery(`text:"open`); err == nil {
+		t.Error("Expected an error for an unterminated quote")
+	}
+}
+
+func TestParseFilterErrors(t *testing.T) {
+	for _, query := range []string{
+		"status>open",
+		"cat<work",
+		"pri<=high",
+		"due<someday",
+		"due>",
+	} {
+		if _, err := parseFilter(query); err == nil {
+			t.Errorf("Expected an error for %q", query)
+		}
+	}
+}
+
+func TestFilterMatch(t *testing.T) {
+	now := time.Date(2030, 3, 10, 12, 0, 0, 0, time.Local)
+	at := func(days int) time.Time { return now.AddDate(0, 0, days) }
+	todos := []*Todo{
+		{UID: "a", Summary: "Send invoice", Categories: []string{"Work"}, Priority: 2, DueDate: at(3), Status: "IN-PROCESS"},
+		{UID: "b", Summary: "Plan trip", Categories: []string{"someday", "work"}, Priority: 5, Status: "NEEDS-ACTION"},
+		{UID: "c", Summary: "Taxes", Description: "Invoice from the accountant", DueDate: at(-2), Status: "NEEDS-ACTION",
+			Collection: &Collection{Name: "Home"}},
+		{UID: "d", Summary: "Old report", Categories: []string{"work"}, Priority: 1, DueDate: at(10), Status: "COMPLETED"},
+	}
+	tests := []struct {
+		query string
+		want  string
+	}{
+		{`cat:work due<7d pri<=3 status:in-process -cat:someday text:"invoice"`, "a"},
+		{"cat:work", "a,b,d"},
+		{"cat:work -cat:someday", "a,d"},
+		{"Category=WORK pri>2", "b"},
+		{"pri:none", "c"},
+		{"-pri:none pri<3", "a,d"},
+		{"invoice", "a,c"},
+		{"due<today", "c"},
+		{"due>=today due<=1w", "a"},
+		{"due:none", "b"},
+		{"due>2030-03-12", "a,d"},
+		{"status:open", "a,b,c"},
+		{"list:home", "c"},
+		{"cat:", "c"},
+		{"uid:b", "b"},
+	}
+	for _, tt := range tests {
+		f, err := parseFilter(tt.query)
+		if err != nil {
+			t.Errorf("%s: %v", tt.query, err)
+			continue
+		}
+		var got []string
+		for _, todo := range todos {
+			if f.Match(todo, now) {
+				got = append(got, todo.UID)
+			}
+		}
+		if strings.Join(got, ",") != tt.want {
+			t.Errorf("%s: got %v, want %s", tt.query, got, tt.want)
+		}
+	}
+}
+
+func TestFilterUnknownFieldIsText(t *testing.T) {
+	todos := []*Todo{
+		{UID: "a", Summary: "Re: lunch on Friday"},
+		{UID: "b", Summary: "Read docs", Description: "See http://example.com/docs"},
+		{UID: "c", Summary: "Colour: red or blue"},
+	}
+	tests := []struct {
+		query string
+		want  string
+	}{
+		{"Re:", "a"},
+		{"re: lunch", "a"},
+		{"http://example.com/docs", "b"},
+		{"-re:", "b,c"},
+		{`"colour: red"`, "c"},
+	}
+	for _, tt := range tests {
+		f, err := parseFilter(tt.query)
+		if err != nil {
+			t.Errorf("%s: %v", tt.query, err)
+			continue
+		}
+		var got []string
+		for _, todo := range todos {
+			if f.Match(todo, time.Now()) {
+				got = append(got, todo.UID)
+			}
+		}
+		if strings.Join(got, ",") != tt.want {
+			t.Errorf("%s: got %v, want %s", tt.query, got, tt.want)
+		}
+	}
+}
+
+func TestFilterInMenuAndCommand(t *testing.T) {
+	t.Cleanup(func() { activeFilter = nil })
+	todoList := &TodoList{Todos: []*Todo{
+		{UID: "a", Summary: "Weed", Categories: []string{"garden"}, Status: "NEEDS-ACTION"},
+		{UID: "b", Summary: "Invoice", Categories: []string{"work"}, Status: "NEEDS-ACTION"},
+		{UID: "c", Summary: "Mulch", Categories: []string{"garden"}, Status: "COMPLETED"},
+	}}
+	s := useMenu(t, "cat<garden", "", "cat:garden")
+	editFilter()
+	if filterQuery() != "cat:garden" || !slicesContainsPrefix(s.prompts, "Bad filter") {
+		t.Errorf("Expected the filter to be set after an error, got %q, prompts %q", filterQuery(), s.prompts)
+	}
+	list := createMenu(todoList, openItems)
+	if lines := list.todoLines(); len(lines) != 1 || list.lookup(lines[0]).UID != "a" {
+		t.Errorf("Expected only the open garden item, got %q", lines)
+	}
+	if list.lookup("Filter: cat:garden") != nil || !strings.Contains(list.String(), "Filter: cat:garden") {
+		t.Errorf("Expected a filter header, got:\n%s", list)
+	}
+
+	// Filtering by status shows completed items in the main list
+	if err := setFilter("status:completed"); err != nil {
+		t.Fatal(err)
+	}
+	if lines := createMenu(todoList, openItems).todoLines(); len(lines) != 1 || !strings.Contains(lines[0], "Mulch") {
+		t.Errorf("Expected the completed item, got %q", lines)
+	}
+	activeFilter = nil
+
+	dir := copyTestdata(t)
+	out, errOut, code := runTestCommand(t, dir, "list", "-all", "-filter", "text:test -status:completed")
+	if code != exitOK {
+		t.Fatalf("list exited %d: %s", code, errOut)
+	}
+	if out == "" || strings.Contains(out, "COMPLETED") {
+		t.Errorf("Unexpected list output:\n%s", out)
+	}
+	if _, _, code := runTestCommand(t, dir, "list", "-filter", "pri<=high"); code != exitUsage {
+		t.Errorf("Expected exit %d for a bad filter, got %d", exitUsage, code)
+	}
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"text/template"
+	"time"
+	"unicode/utf8"
+)
+
+// lineTemplate renders list lines when -format is set, nil for the built-in
+// layout.
+var lineTemplate *template.Template
+
+// lineFields are the fields a -format template can use.
+type lineFields struct {
+	Priority    int    // 0 if unset
+	Status      string // NEEDS-ACTION, IN-PROCESS, COMPLETED or CANCELLED
+	Percent     string // Percent complete as [40%], "" if unset
+	Summary     string // Title
+	Indent      string // Subtask marker, "" for top level items
+	Categories  string // Space separated @categories
+	Due         string // yyyy-mm-dd
+	DueTime     string // hh:mm, "" for all day
+	RelDue      string // "in 3d", "today" or "overdue 2d"
+	Start       string // yyyy-mm-dd
+	Created     string // yyyy-mm-dd
+	Repeat      string // Recurrence summary
+	List        string // Name of the list
+	Progress    string // Closed subtasks, e.g. "2/5"
+	Description string // First line of the description
+}
+
+// lineFuncs help line up columns in monospace launchers.
+var lineFuncs = template.FuncMap{
+	// pad left aligns v in a column n characters wide
+	"pad": func(n int, v any) string {
+		s := fmt.Sprint(v)
+		return s + strings.Repeat(" ", max(n-utf8.RuneCountInString(s), 0))
+	},
+	// lpad right aligns v in a column n characters wide
+	"lpad": func(n int, v any) string {
+		s := fmt.Sprint(v)
+		return strings.Repeat(" ", max(n-utf8.RuneCountInString(s), 0)) + s
+	},
+	// trunc shortens s to n characters, ending in … if it was cut
+	"trunc": func(n int, s string) string {
+		if utf8.RuneCountInString(s) <= n {
+			return s
+		}
+		if n < 1 {
+			return ""
+		}
+		return string([]rune(s)[:n-1]) + "…"
+	},
+}
+
+// setLineFormat parses the -format template. An empty format selects the
+// built-in layout.
+func setLineFormat(format string) error {
+	tmpl, err := parseLineFormat(format)
+	if err != nil {
+		return err
+	}
+	lineTemplate = tmpl
+	return nil
+}
+
+func parseLineFormat(format string) (*template.Template, error) {
+	if format == "" {
+		return nil, nil
+	}
+	return template.New("format").Funcs(lineFuncs).Parse(format)
+}
+
+// newLineFields collects the template fields of a todo.
+func newLineFields(todoList *TodoList, todo *Todo, depth int, now time.Time) lineFields {
+	f := lineFields{
+		Priority: todo.Priority,
+		Status:   todo.Status,
+		Percent:  formatPercent(todo),
+		Summary:  todo.Summary,
+		Due:      formatDate(todo.DueDate),
+		Start:    formatDate(todo.StartDate),
+		Created:  formatDate(todo.Created),
+		Repeat:   formatRRule(todo.RRule),
+		List:     listName(todo),
+		RelDue:   relativeDue(todo.DueDate, now),
+	}
+	if depth > 0 {
+		f.Indent = strings.Repeat("  ", depth-1) + "↳ "
+	}
+	var cats []string
+	for _, cat := range todo.Categories {
+		cats = append(cats, "@"+cat)
+	}
+	f.Categories = strings.Join(cats, " ")
+	if !todo.DueDate.IsZero() && todo.DueKind != DateOnly {
+		f.DueTime = formatTime(todo.DueDate.In(time.Local))
+	}
+	if kids := children(todoList, todo); len(kids) > 0 {
+		done := 0
+		for _, kid := range kids {
+			if !isOpen(kid) {
+				done++
+			}
+		}
+		f.Progress = fmt.Sprintf("%d/%d", done, len(kids))
+	}
+	f.Description, _, _ = strings.Cut(strings.TrimSpace(todo.Description), "\n")
+	return f
+}
+
+// relativeDue describes a due date in days from now: "in 3d", "today" or
+// "overdue 2d".
+func relativeDue(due, now time.Time) string {
+	if due.IsZero() {
+		return ""
+	}
+	switch days := daysUntil(due, now); {
+	case days > 0:
+		return fmt.Sprintf("in %dd", days)
+	case days < 0:
+		return fmt.Sprintf("overdue %dd", -days)
+	}
+	return "today"
+}
+
+// daysUntil returns the number of calendar days from now to t, negative if
+// t has passed.
+func daysUntil(t, now time.Time) int {
+	t, now = t.In(time.Local), now.In(time.Local)
+	day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	return int(day.Sub(today).Hours() / 24)
+}
+
+// formatTemplateLine renders a todo with the -format template. Newlines are
+// replaced so the result stays on one menu line.
+func formatTemplateLine(todoList *TodoList, todo *Todo, depth int) (string, error) {
+	var b strings.Builder
+	if err := lineTemplate.Execute(&b, newLineFields(todoList, todo, depth, time.Now())); err != nil {
+		return "", err
+	}
+	return strings.NewReplacer("\r\n", " ", "\n", " ", "\r", " ").Replace(b.String()), nil
+}
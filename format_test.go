@@ -0,0 +1,81 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// useLineFormat sets the -format template for one test.
+func useLineFormat(t *testing.T, format string) {
+	t.Helper()
+	if err := setLineFormat(format); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { lineTemplate = nil })
+}
+
+func TestRelativeDue(t *testing.T) {
+	now := time.Date(2030, 3, 10, 18, 0, 0, 0, time.Local)
+	tests := []struct {
+		due  time.Time
+		want string
+	}{
+		{time.Time{}, ""},
+		{time.Date(2030, 3, 10, 9, 0, 0, 0, time.Local), "today"},
+		{time.Date(2030, 3, 13, 0, 0, 0, 0, time.Local), "in 3d"},
+		{time.Date(2030, 3, 8, 23, 0, 0, 0, time.Local), "overdue 2d"},
+		{time.Date(2030, 4, 10, 0, 0, 0, 0, time.Local), "in 31d"},
+	}
+	for _, tt := range tests {
+		if got := relativeDue(tt.due, now); got != tt.want {
+			t.Errorf("relativeDue(%v) = %q, want %q", tt.due, got, tt.want)
+		}
+	}
+}
+
+func TestFormatTemplateLine(t *testing.T) {
+	parent := &Todo{
+		UID: "p", Summary: "Taxes", Priority: 2, Categories: []string{"home", "money"},
+		DueDate: time.Date(2030, 4, 15, 17, 30, 0, 0, time.Local), DueKind: DateTimeUTC,
+		Description: "Forms are in the drawer\nand online",
+		Collection:  &Collection{Name: "Personal"},
+	}
+	done := &Todo{UID: "c1", Summary: "Find receipts", ParentUID: "p", Status: "COMPLETED"}
+	open := &Todo{UID: "c2", Summary: "File return\nonline", ParentUID: "p", Status: "NEEDS-ACTION"}
+	todoList := &TodoList{Todos: []*Todo{parent, done, open}}
+
+	tests := []struct {
+		format string
+		todo   *Todo
+		depth  int
+		want   string
+	}{
+		{"({{.Priority}}) {{.Summary}} {{.Categories}} {{.Due}} {{.DueTime}}", parent, 0, "(2) Taxes @home @money 2030-04-15 17:30"},
+		{"{{.List}}: {{.Progress}} {{.Description}}", parent, 0, "Personal: 1/2 Forms are in the drawer"},
+		{"[{{pad 8 .Summary}}]|{{lpad 3 .Priority}}", parent, 0, "[Taxes   ]|  2"},
+		{"{{trunc 6 .Summary}}", done, 0, "Find …"},
+		{"{{.Indent}}{{.Summary}}", open, 2, "  ↳ File return online"},
+		{"{{if .Priority}}!{{end}}{{.Progress}}{{.Due}}", open, 0, ""},
+	}
+	for _, tt := range tests {
+		useLineFormat(t, tt.format)
+		if got := formatTodoLine(todoList, tt.todo, tt.depth); got != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.format, got, tt.want)
+		}
+	}
+}
+
+func TestFormatTemplateErrors(t *testing.T) {
+	if err := setLineFormat("{{.Summary"); err == nil {
+		t.Error("Expected an error for an unclosed action")
+	}
+
+	// Errors while rendering fall back to the built-in layout
+	todo := &Todo{Summary: "Fallback", Created: time.Now()}
+	todoList := &TodoList{Todos: []*Todo{todo}}
+	want := formatTodoLine(todoList, todo, 0)
+	useLineFormat(t, "{{.NoSuchField}}")
+	if got := formatTodoLine(todoList, todo, 0); got != want {
+		t.Errorf("Expected the default layout %q, got %q", want, got)
+	}
+}
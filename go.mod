@@ -2,4 +2,7 @@ module github.com/firecat53/todocalmenu
 
 go 1.22.6
 
-require github.com/arran4/golang-ical v0.3.1
+require (
+	github.com/BurntSushi/toml v1.6.0
+	github.com/arran4/golang-ical v0.3.1
+)
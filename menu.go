@@ -0,0 +1,305 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// errEscape is returned when the user backs out of a menu.
+var errEscape = errors.New("escape")
+
+// Menu shows lists and asks for input. The launchers are the real
+// implementations; tests use a scripted one.
+type Menu interface {
+	// Show offers a newline separated list and returns the chosen line, or
+	// the text typed if nothing matched. It returns errEscape if cancelled.
+	Show(list, prompt string) (string, error)
+	// Prompt asks for free text, offering current as the default.
+	Prompt(prompt, current string) (string, error)
+	// Confirm asks a yes/no question, defaulting to no.
+	Confirm(question string) bool
+}
+
+// menu is the Menu used by the interactive screens.
+var menu Menu
+
+// launcherMenu runs a dmenu style launcher that reads choices on stdin and
+// prints the selection on stdout.
+type launcherMenu struct {
+	cmd  string
+	args func(prompt string) []string // Launcher flags for a prompt
+	opts []string                     // Extra user options from -opts
+	// Exit codes meaning the user cancelled
+	cancel []int
+	// fzf prints the typed query and the selection on separate lines and
+	// exits 1 when nothing matched.
+	printQuery bool
+	// The launcher draws on the terminal, so its stderr is passed through
+	terminal bool
+	// "rofi" or "fzf" for launchers with custom keys, multi-select and a
+	// way to report which line was picked
+	style string
+}
+
+// newMenu returns the launcher backend for cmd, which may be a path, or
+// the built-in terminal UI for "tui". Unknown launchers are assumed to take
+// dmenu's flags.
+func newMenu(cmd, opts string) Menu {
+	if cmd == "tui" {
+		return &tuiMenu{}
+	}
+	l := &launcherMenu{cmd: cmd, opts: strings.Fields(opts), cancel: []int{1}}
+	switch filepath.Base(cmd) {
+	case "rofi":
+		// Print the index too, so the exact line is known
+		l.args = func(p string) []string { return []string{"-dmenu", "-i", "-p", p, "-format", "i s"} }
+		l.style = "rofi"
+	case "wofi":
+		l.args = func(p string) []string { return []string{"--dmenu", "-i", "-p", p} }
+	case "fuzzel", "walker":
+		// Both match case-insensitively already
+		l.args = func(p string) []string { return []string{"--dmenu", "-p", p} }
+	case "tofi":
+		// Allow typed text that doesn't match any entry
+		l.args = func(p string) []string { return []string{"--prompt-text", p, "--require-match=false"} }
+	case "fzf":
+		// Lines are sent with a hidden index column
+		l.args = func(p string) []string {
+			return []string{"--prompt", p + " ", "--print-query", "--delimiter=\t", "--with-nth=2.."}
+		}
+		l.cancel = []int{130}
+		l.printQuery = true
+		l.terminal = true
+		l.style = "fzf"
+	default:
+		// dmenu, bemenu, wmenu and yofi
+		l.args = func(p string) []string { return []string{"-i", "-p", p} }
+	}
+	return l
+}
+
+func (l *launcherMenu) Show(list, prompt string) (string, error) {
+	out, _, err := l.run(list, l.args(prompt), nil)
+	if err != nil {
+		return "", err
+	}
+	if l.printQuery {
+		query, selection, _ := strings.Cut(out, "\n")
+		if selection != "" {
+			return l.line(list, selection), nil
+		}
+		return query, nil
+	}
+	return l.line(list, out), nil
+}
+
+// Shortcut keys used with launchers that support them when none are
+// configured. rofi binds Alt+1 to Alt+5 to its first custom keys already.
+var (
+	rofiKeys = map[string]string{
+		"Alt+1": "complete",
+		"Alt+2": "delete",
+		"Alt+3": "priority-up",
+		"Alt+4": "priority-down",
+		"Alt+5": "postpone",
+	}
+	fzfKeys = map[string]string{
+		"alt-c":    "complete",
+		"alt-d":    "delete",
+		"alt-up":   "priority-up",
+		"alt-down": "priority-down",
+		"alt-p":    "postpone",
+	}
+)
+
+// rofi has 19 custom keys, reported as exit codes 10 to 28
+const rofiCustomKeys = 19
+
+func (l *launcherMenu) DefaultKeys() map[string]string {
+	switch l.style {
+	case "rofi":
+		return rofiKeys
+	case "fzf":
+		return fzfKeys
+	}
+	return nil
+}
+
+// ShowKeys passes the keys to rofi as -kb-custom-N bindings, or to fzf with
+// --expect. Other launchers just show the list.
+func (l *launcherMenu) ShowKeys(list, prompt string, keys map[string]string) (string, string, error) {
+	names := sortedKeys(keys)
+	args := l.args(prompt)
+	switch {
+	case l.style == "rofi" && len(names) > 0:
+		if len(names) > rofiCustomKeys {
+			log.Printf("rofi only has %d custom keys, ignoring %v", rofiCustomKeys, names[rofiCustomKeys:])
+			names = names[:rofiCustomKeys]
+		}
+		var help []string
+		for i, name := range names {
+			args = append(args, fmt.Sprintf("-kb-custom-%d", i+1), name)
+			help = append(help, name+" "+keys[name])
+		}
+		args = append(args, "-mesg", strings.Join(help, "  "))
+		out, code, err := l.run(list, args, func(code int) bool {
+			return code >= 10 && code < 10+len(names)
+		})
+		if err != nil || code == 0 {
+			return l.line(list, out), "", err
+		}
+		return l.line(list, out), names[code-10], nil
+	case l.style == "fzf" && len(names) > 0:
+		args = append(args, "--expect="+strings.Join(names, ","))
+		out, _, err := l.run(list, args, nil)
+		if err != nil {
+			return "", "", err
+		}
+		// The query, the key pressed or an empty line, then the selection
+		lines := strings.SplitN(out, "\n", 3)
+		for len(lines) < 3 {
+			lines = append(lines, "")
+		}
+		if lines[2] == "" {
+			return lines[0], lines[1], nil
+		}
+		return l.line(list, lines[2]), lines[1], nil
+	}
+	out, err := l.Show(list, prompt)
+	return out, "", err
+}
+
+// ShowMulti uses rofi's -multi-select or fzf's --multi. Other launchers
+// return errors.ErrUnsupported.
+func (l *launcherMenu) ShowMulti(list, prompt string) ([]string, error) {
+	args := l.args(prompt)
+	switch l.style {
+	case "rofi":
+		args = append(args, "-multi-select")
+	case "fzf":
+		args = append(args, "--multi")
+	default:
+		return nil, errors.ErrUnsupported
+	}
+	out, _, err := l.run(list, args, nil)
+	if err != nil || out == "" {
+		return nil, err
+	}
+	lines := strings.Split(out, "\n")
+	if l.printQuery {
+		// Drop the query
+		lines = lines[1:]
+	}
+	for i, line := range lines {
+		lines[i] = l.line(list, line)
+	}
+	return lines, nil
+}
+
+// line maps a line of rofi or fzf output back to the exact line of list it
+// stands for using the index printed with it, so lines that look the same
+// or were trimmed by the launcher are still told apart. Typed text and
+// output from other launchers is returned unchanged.
+func (l *launcherMenu) line(list, out string) string {
+	var sep string
+	switch l.style {
+	case "rofi":
+		sep = " "
+	case "fzf":
+		sep = "\t"
+	default:
+		return out
+	}
+	index, text, ok := strings.Cut(out, sep)
+	n, err := strconv.Atoi(index)
+	if !ok || err != nil {
+		return out
+	}
+	lines := strings.Split(list, "\n")
+	if n < 0 || n >= len(lines) || list == "" {
+		// rofi prints -1 for typed text
+		return text
+	}
+	return lines[n]
+}
+
+// run starts the launcher and returns its output and exit status. It
+// returns errEscape for the cancel codes, and an error for other failures
+// unless expected reports the status is a meaningful one.
+func (l *launcherMenu) run(list string, args []string, expected func(code int) bool) (string, int, error) {
+	var out, outErr bytes.Buffer
+	cmd := exec.Command(l.cmd, append(args, l.opts...)...)
+	if l.style == "fzf" && list != "" {
+		lines := strings.Split(list, "\n")
+		for i, line := range lines {
+			lines[i] = strconv.Itoa(i) + "\t" + line
+		}
+		list = strings.Join(lines, "\n")
+	}
+	if l.style == "rofi" && strings.Contains(list, groupPrefix) {
+		// Group headings can't be selected
+		lines := strings.Split(list, "\n")
+		for i, line := range lines {
+			if isGroupHeader(line) {
+				lines[i] = line + "\x00nonselectable\x1ftrue"
+			}
+		}
+		list = strings.Join(lines, "\n")
+	}
+	cmd.Stdin = strings.NewReader(list)
+	cmd.Stdout = &out
+	cmd.Stderr = &outErr
+	if l.terminal {
+		cmd.Stderr = os.Stderr
+	}
+	err := cmd.Run()
+	code := 0
+	var exitErr *exec.ExitError
+	switch {
+	case errors.As(err, &exitErr):
+		code = exitErr.ExitCode()
+		if slices.Contains(l.cancel, code) {
+			return "", code, errEscape
+		}
+		// fzf exits 1 when the typed text matches nothing
+		if (!l.printQuery || code != 1) && (expected == nil || !expected(code)) {
+			return "", code, fmt.Errorf("%s exited with status %d: %s", l.cmd, code, strings.TrimSpace(outErr.String()))
+		}
+	case err != nil:
+		return "", 0, err
+	}
+	return strings.TrimRight(out.String(), "\n"), code, nil
+}
+
+// Prompt offers current as the only line to pick. fzf would pick it for any
+// typed text that matches it, so there current starts out as the query and
+// the query is always returned.
+func (l *launcherMenu) Prompt(prompt, current string) (string, error) {
+	if !l.printQuery {
+		return l.Show(current, prompt)
+	}
+	out, _, err := l.run("", append(l.args(prompt), "--query", current), nil)
+	if err != nil {
+		return "", err
+	}
+	query, _, _ := strings.Cut(out, "\n")
+	return query, nil
+}
+
+func (l *launcherMenu) Confirm(question string) bool {
+	out, err := l.Show("", question+" (y/N)")
+	return err == nil && isYes(out)
+}
+
+func isYes(s string) bool {
+	s = strings.ToLower(strings.TrimSpace(s))
+	return s == "y" || s == "yes"
+}
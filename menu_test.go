@@ -0,0 +1,338 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// menuEscape as a scripted answer cancels the menu like Esc does.
+const menuEscape = "\x1b"
+
+// scriptedMenu is a Menu that replays canned answers in order and records
+// the prompts it was shown. Running out of answers cancels.
+type scriptedMenu struct {
+	answers []string
+	prompts []string
+}
+
+func (s *scriptedMenu) next(prompt string) (string, error) {
+	s.prompts = append(s.prompts, prompt)
+	if len(s.answers) == 0 {
+		return "", errEscape
+	}
+	answer := s.answers[0]
+	s.answers = s.answers[1:]
+	if answer == menuEscape {
+		return "", errEscape
+	}
+	return answer, nil
+}
+
+func (s *scriptedMenu) Show(list, prompt string) (string, error) { return s.next(prompt) }
+
+func (s *scriptedMenu) Prompt(prompt, current string) (string, error) { return s.next(prompt) }
+
+func (s *scriptedMenu) Confirm(question string) bool {
+	answer, err := s.next(question)
+	return err == nil && isYes(answer)
+}
+
+// useMenu replaces the global menu with scripted answers for one test.
+func useMenu(t *testing.T, answers ...string) *scriptedMenu {
+	t.Helper()
+	s := &scriptedMenu{answers: answers}
+	old := menu
+	menu = s
+	t.Cleanup(func() { menu = old })
+	return s
+}
+
+func TestAddItemWithMenu(t *testing.T) {
+	dir := t.TempDir()
+	todoList := &TodoList{Collections: []*Collection{{Name: "todos", Dir: dir}}}
+	useMenu(t, "Buy milk @home !2")
+	addItem(todoList, "")
+	if len(todoList.Todos) != 1 {
+		t.Fatalf("Expected 1 todo, got %d", len(todoList.Todos))
+	}
+	todo := todoList.Todos[0]
+	if todo.Summary != "Buy milk" || todo.Priority != 2 || todo.Modified {
+		t.Errorf("Unexpected todo %+v", todo)
+	}
+	if saved := mustLoad(t, dir); len(saved.Todos) != 1 || saved.Todos[0].Summary != "Buy milk" {
+		t.Error("Expected the quick-added todo to be saved straight away")
+	}
+
+	// Review in the edit menu, then cancel without saving
+	useMenu(t, "Call Bob ?", "Priority: 0", "4", menuEscape)
+	addItem(todoList, "")
+	if len(todoList.Todos) != 1 {
+		t.Errorf("Expected the cancelled todo not to be added, got %d todos", len(todoList.Todos))
+	}
+}
+
+func TestEditItemWithMenu(t *testing.T) {
+	todo := &Todo{UID: "a", Summary: "Old", Status: "NEEDS-ACTION"}
+	todoList := &TodoList{Todos: []*Todo{todo}}
+	s := useMenu(t,
+		"Title: Old", "New",
+		"Priority: 0", "12", "", // Out of range shows an error
+		"Priority: 0", "3",
+		"Due date yyyy-mm-dd: ", "2030-02-01",
+		"Save item",
+	)
+	editItem(todo, todoList)
+	if todo.Summary != "New" || todo.Priority != 3 || formatDate(todo.DueDate) != "2030-02-01" || !todo.Modified {
+		t.Errorf("Unexpected todo after edit: %+v", todo)
+	}
+	if !slicesContainsPrefix(s.prompts, "Priority must be") {
+		t.Errorf("Expected a priority error message, prompts were %q", s.prompts)
+	}
+
+	// Escape reverts an existing item
+	useMenu(t, "Title: New", "Newer", menuEscape)
+	editItem(todo, todoList)
+	if todo.Summary != "New" {
+		t.Errorf("Expected escape to revert the title, got %q", todo.Summary)
+	}
+}
+
+func TestDeleteItemWithMenu(t *testing.T) {
+	todo := &Todo{UID: "a", Summary: "Gone", Status: "NEEDS-ACTION"}
+	todoList := &TodoList{Todos: []*Todo{todo}}
+	useMenu(t, "Delete item", "n", "Delete item", "y")
+	editItem(todo, todoList)
+	if len(todoList.Todos) != 0 {
+		t.Errorf("Expected the todo to be deleted, got %d todos", len(todoList.Todos))
+	}
+}
+
+func TestViewCompletedItemsWithMenu(t *testing.T) {
+	dir := copyTestdata(t)
+	old := *todoPtr
+	*todoPtr = dir
+	defer func() { *todoPtr = old }()
+
+	todoList := mustLoad(t, dir)
+	var completed int
+	for _, todo := range todoList.Todos {
+		if todo.Status == "COMPLETED" {
+			completed++
+		}
+	}
+	useMenu(t, "Delete All Completed", "y")
+	viewClosedItems(todoList, completedItems)
+	for _, todo := range todoList.Todos {
+		if todo.Status == "COMPLETED" {
+			t.Errorf("Expected %q to be deleted", todo.Summary)
+		}
+	}
+	if reloaded := mustLoad(t, dir); len(reloaded.Todos) != 6-completed {
+		t.Errorf("Expected %d files left, got %d", 6-completed, len(reloaded.Todos))
+	}
+}
+
+func TestDeleteAllCompletedKeepsHiddenLists(t *testing.T) {
+	root := t.TempDir()
+	for _, l := range []struct{ name, uid, summary string }{{"Work", "w", "Work done"}, {"Home", "h", "Home done"}} {
+		dir := filepath.Join(root, strings.ToLower(l.name))
+		writeList(t, dir, l.name, l.uid, l.summary)
+		data := strings.Replace(fmt.Sprintf(listTestTodo, l.uid, l.summary), "NEEDS-ACTION", "COMPLETED", 1)
+		if err := os.WriteFile(filepath.Join(dir, l.uid+".ics"), []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	todoList := loadListTestDir(t, root)
+	defer func(old string) { *listFilterPtr = old }(*listFilterPtr)
+	*listFilterPtr = "Work"
+
+	useMenu(t, "Delete All Completed", "y")
+	viewClosedItems(todoList, completedItems)
+	if _, err := os.Stat(filepath.Join(root, "work", "w.ics")); !os.IsNotExist(err) {
+		t.Error("Expected the shown item to be deleted")
+	}
+	if _, err := os.Stat(filepath.Join(root, "home", "h.ics")); err != nil {
+		t.Errorf("Expected the item in the hidden list to be kept: %v", err)
+	}
+	if len(todoList.Todos) != 1 || todoList.Todos[0].UID != "h" {
+		t.Errorf("Expected only the hidden item left, got %d todos", len(todoList.Todos))
+	}
+}
+
+func TestDeleteAllCompletedKeepsFilteredOut(t *testing.T) {
+	t.Cleanup(func() { activeFilter = nil })
+	todoList := &TodoList{Todos: []*Todo{
+		{UID: "a", Summary: "Weed", Categories: []string{"garden"}, Status: "COMPLETED"},
+		{UID: "b", Summary: "Invoice", Categories: []string{"work"}, Status: "COMPLETED"},
+	}}
+	if err := setFilter("cat:garden"); err != nil {
+		t.Fatal(err)
+	}
+	useMenu(t, "Delete All Completed", "y")
+	viewClosedItems(todoList, completedItems)
+	if len(todoList.Todos) != 1 || todoList.Todos[0].UID != "b" {
+		t.Errorf("Expected only the item the filter hides to be kept, got %d todos", len(todoList.Todos))
+	}
+}
+
+func TestDeleteAllCompletedMovesSubtasksUp(t *testing.T) {
+	todoList := &TodoList{Todos: []*Todo{
+		{UID: "top", Summary: "Garden", Status: "NEEDS-ACTION"},
+		{UID: "mid", Summary: "Beds", ParentUID: "top", Status: "COMPLETED"},
+		{UID: "low", Summary: "Weed", ParentUID: "mid", Status: "NEEDS-ACTION"},
+	}}
+	useMenu(t, "Delete All Completed", "y")
+	viewClosedItems(todoList, completedItems)
+	if len(todoList.Todos) != 2 {
+		t.Fatalf("Expected the completed item deleted, got %d todos", len(todoList.Todos))
+	}
+	if low := todoList.Todos[1]; low.ParentUID != "top" || !low.Modified {
+		t.Errorf("Expected the open subtask moved up to top, got parent %q", low.ParentUID)
+	}
+}
+
+// fakeLauncher writes a shell script named name that records its arguments
+// and stdin, prints output and exits with code.
+func fakeLauncher(t *testing.T, name, output string, code int) (path, argsFile string) {
+	t.Helper()
+	dir := t.TempDir()
+	path = filepath.Join(dir, name)
+	argsFile = filepath.Join(dir, "args")
+	script := "#!/bin/sh\nprintf '%s\\n' \"$@\" > " + argsFile + "\ncat > /dev/null\n" +
+		"printf -- '" + output + "'\nexit " + strconv.Itoa(code) + "\n"
+	if err := os.WriteFile(path, []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	return path, argsFile
+}
+
+func TestLauncherMenuFlags(t *testing.T) {
+	tests := []struct {
+		name   string
+		output string
+		args   []string
+	}{
+		{"dmenu", "b\\n", []string{"-i", "-p", "Pick:", "-l", "10"}},
+		{"bemenu", "b\\n", []string{"-i", "-p", "Pick:", "-l", "10"}},
+		{"rofi", "1 b\\n", []string{"-dmenu", "-i", "-p", "Pick:", "-format", "i s", "-l", "10"}},
+		{"wofi", "b\\n", []string{"--dmenu", "-i", "-p", "Pick:", "-l", "10"}},
+		{"fuzzel", "b\\n", []string{"--dmenu", "-p", "Pick:", "-l", "10"}},
+		{"tofi", "b\\n", []string{"--prompt-text", "Pick:", "--require-match=false", "-l", "10"}},
+		{"fzf", "\\n1\\tb\\n", []string{"--prompt", "Pick: ", "--print-query", "--delimiter=\t", "--with-nth=2..", "-l", "10"}},
+	}
+	for _, tt := range tests {
+		path, argsFile := fakeLauncher(t, tt.name, tt.output, 0)
+		out, err := newMenu(path, "-l 10").Show("a\nb", "Pick:")
+		if err != nil || out != "b" {
+			t.Errorf("%s: got %q, %v", tt.name, out, err)
+		}
+		data, _ := os.ReadFile(argsFile)
+		if args := strings.Split(strings.TrimSuffix(string(data), "\n"), "\n"); !reflect.DeepEqual(args, tt.args) {
+			t.Errorf("%s: called with %q, expected %q", tt.name, args, tt.args)
+		}
+	}
+}
+
+func TestLauncherMenuExitCodes(t *testing.T) {
+	path, _ := fakeLauncher(t, "dmenu", "", 1)
+	if _, err := newMenu(path, "").Show("a", "Pick:"); !errors.Is(err, errEscape) {
+		t.Errorf("Expected exit 1 from dmenu to cancel, got %v", err)
+	}
+	path, _ = fakeLauncher(t, "dmenu", "", 2)
+	if _, err := newMenu(path, "").Show("a", "Pick:"); err == nil || errors.Is(err, errEscape) {
+		t.Errorf("Expected exit 2 from dmenu to be an error, got %v", err)
+	}
+
+	// fzf exits 1 when the typed text matches nothing
+	path, _ = fakeLauncher(t, "fzf", "typed\\n", 1)
+	if out, err := newMenu(path, "").Show("a", "Pick:"); err != nil || out != "typed" {
+		t.Errorf("Expected fzf query to be returned, got %q, %v", out, err)
+	}
+	path, _ = fakeLauncher(t, "fzf", "", 130)
+	if _, err := newMenu(path, "").Show("a", "Pick:"); !errors.Is(err, errEscape) {
+		t.Errorf("Expected exit 130 from fzf to cancel, got %v", err)
+	}
+
+	// A prompt returns the typed text even if fzf matched it to a line
+	path, argsFile := fakeLauncher(t, "fzf", "Buy mil\\n0\\tBuy milk\\n", 0)
+	if out, err := newMenu(path, "").Prompt("Todo Title:", "Buy milk"); err != nil || out != "Buy mil" {
+		t.Errorf("Expected the fzf query to be returned, got %q, %v", out, err)
+	}
+	if data, _ := os.ReadFile(argsFile); !strings.Contains(string(data), "--query\nBuy milk\n") {
+		t.Errorf("Expected the current value as the query, got %q", data)
+	}
+
+	path, _ = fakeLauncher(t, "rofi", "-1 Y\\n", 0)
+	if !newMenu(path, "").Confirm("Sure?") {
+		t.Error("Expected Y to confirm")
+	}
+}
+
+func slicesContainsPrefix(list []string, prefix string) bool {
+	for _, s := range list {
+		if strings.HasPrefix(s, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func TestLauncherMenuShowKeys(t *testing.T) {
+	keys := map[string]string{"Alt+c": "complete", "Alt+d": "delete"}
+
+	// rofi reports the second custom key as exit status 11
+	path, argsFile := fakeLauncher(t, "rofi", "0 (1) Milk\\n", 11)
+	m := newMenu(path, "").(keyMenu)
+	out, key, err := m.ShowKeys("(1) Milk", "todos", keys)
+	if err != nil || out != "(1) Milk" || key != "Alt+d" {
+		t.Errorf("Expected Alt+d on Milk, got %q, %q, %v", out, key, err)
+	}
+	data, _ := os.ReadFile(argsFile)
+	if !strings.Contains(string(data), "-kb-custom-1\nAlt+c\n-kb-custom-2\nAlt+d\n") {
+		t.Errorf("Expected custom key flags, got %q", data)
+	}
+	path, _ = fakeLauncher(t, "rofi", "", 12)
+	if _, _, err := newMenu(path, "").(keyMenu).ShowKeys("a", "todos", keys); err == nil {
+		t.Error("Expected an unbound custom key to be an error")
+	}
+
+	path, argsFile = fakeLauncher(t, "fzf", "\\nalt-c\\n0\\t(1) Milk\\n", 0)
+	out, key, err = newMenu(path, "").(keyMenu).ShowKeys("(1) Milk", "todos", map[string]string{"alt-c": "complete"})
+	if err != nil || out != "(1) Milk" || key != "alt-c" {
+		t.Errorf("Expected alt-c on Milk, got %q, %q, %v", out, key, err)
+	}
+	if data, _ := os.ReadFile(argsFile); !strings.Contains(string(data), "--expect=alt-c") {
+		t.Errorf("Expected --expect, got %q", data)
+	}
+
+	// Launchers without custom keys just show the list
+	path, argsFile = fakeLauncher(t, "dmenu", "(1) Milk\\n", 0)
+	m = newMenu(path, "").(keyMenu)
+	if m.DefaultKeys() != nil {
+		t.Error("Expected no default keys for dmenu")
+	}
+	out, key, err = m.ShowKeys("(1) Milk", "todos", keys)
+	if err != nil || out != "(1) Milk" || key != "" {
+		t.Errorf("Expected a plain selection, got %q, %q, %v", out, key, err)
+	}
+	if data, _ := os.ReadFile(argsFile); strings.Contains(string(data), "Alt+c") {
+		t.Errorf("Expected no key flags for dmenu, got %q", data)
+	}
+}
+
+func TestMenuKeys(t *testing.T) {
+	keys := menuKeys(&tuiMenu{}, "Alt+c=complete, += priority-up,Alt+x=explode,bad")
+	expected := map[string]string{"Alt+c": "complete", "+": "priority-up"}
+	if !reflect.DeepEqual(keys, expected) {
+		t.Errorf("Expected %v, got %v", expected, keys)
+	}
+	if keys := menuKeys(newMenu("rofi", ""), ""); keys["Alt+1"] != "complete" {
+		t.Errorf("Expected rofi defaults, got %v", keys)
+	}
+}
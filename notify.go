@@ -0,0 +1,152 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Alarms older than this are not delivered, so starting the daemon after a
+// long break doesn't flood the desktop with stale reminders.
+const notifyLookback = 24 * time.Hour
+
+// pendingAlarm is an alarm that is due to be delivered.
+type pendingAlarm struct {
+	Key  string
+	Todo *Todo
+	Time time.Time
+}
+
+// runNotifier periodically scans the todo directories and runs the notifier
+// command for every alarm that has fired and hasn't been delivered yet.
+func runNotifier(dirs, notifyCmd string, interval time.Duration) {
+	statePath := notifyStatePath()
+	delivered, err := loadDelivered(statePath)
+	if err != nil {
+		log.Printf("Error loading notification state: %v", err)
+	}
+	for {
+		collections, err := findCollections(dirs)
+		var todoList *TodoList
+		if err == nil {
+			// Lists added since the last scan are picked up too
+			todoList, err = loadCollections(collections)
+		}
+		if err != nil {
+			log.Printf("Error loading todos: %v", err)
+		} else {
+			now := time.Now()
+			for _, p := range dueAlarms(todoList, delivered, now) {
+				if err := sendNotification(notifyCmd, p); err != nil {
+					log.Printf("Error sending notification for %s: %v", p.Todo.Summary, err)
+					continue
+				}
+				delivered[p.Key] = p.Time
+			}
+			if err := saveDelivered(statePath, delivered, now); err != nil {
+				log.Printf("Error saving notification state: %v", err)
+			}
+		}
+		time.Sleep(interval)
+	}
+}
+
+// dueAlarms returns the alarms of open todos that fired between
+// notifyLookback ago and now and are not in delivered.
+func dueAlarms(todoList *TodoList, delivered map[string]time.Time, now time.Time) []pendingAlarm {
+	var pending []pendingAlarm
+	for _, todo := range todoList.Todos {
+		if !isOpen(todo) {
+			continue
+		}
+		for _, a := range todo.Alarms {
+			t := a.Time(todo)
+			if t.IsZero() || t.After(now) || now.Sub(t) > notifyLookback {
+				continue
+			}
+			key := fmt.Sprintf("%s|%s|%s", todo.UID, a.key(), t.UTC().Format("20060102T150405Z"))
+			if _, ok := delivered[key]; ok {
+				continue
+			}
+			pending = append(pending, pendingAlarm{Key: key, Todo: todo, Time: t})
+		}
+	}
+	sort.Slice(pending, func(i, j int) bool { return pending[i].Time.Before(pending[j].Time) })
+	return pending
+}
+
+func sendNotification(notifyCmd string, p pendingAlarm) error {
+	args := strings.Fields(notifyCmd)
+	if len(args) == 0 {
+		return fmt.Errorf("empty notifier command")
+	}
+	body := p.Todo.Description
+	if !p.Todo.DueDate.IsZero() {
+		body = strings.TrimSpace(fmt.Sprintf("Due %s\n%s",
+			p.Todo.DueDate.Local().Format("2006-01-02 15:04"), body))
+	}
+	args = append(args, p.Todo.Summary, body)
+	return exec.Command(args[0], args[1:]...).Run()
+}
+
+func notifyStatePath() string {
+	dir := os.Getenv("XDG_STATE_HOME")
+	if dir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return filepath.Join(os.TempDir(), "todocalmenu-delivered")
+		}
+		dir = filepath.Join(home, ".local", "state")
+	}
+	return filepath.Join(dir, "todocalmenu", "delivered")
+}
+
+// loadDelivered reads the delivered alarm keys, one "key<TAB>time" per line.
+func loadDelivered(path string) (map[string]time.Time, error) {
+	delivered := make(map[string]time.Time)
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return delivered, nil
+	}
+	if err != nil {
+		return delivered, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		parts := strings.SplitN(scanner.Text(), "\t", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, parts[1])
+		if err != nil {
+			continue
+		}
+		delivered[parts[0]] = t
+	}
+	return delivered, scanner.Err()
+}
+
+// saveDelivered writes the delivered alarm keys, dropping entries that are
+// too old to ever be delivered again.
+func saveDelivered(path string, delivered map[string]time.Time, now time.Time) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	var b strings.Builder
+	for key, t := range delivered {
+		if now.Sub(t) > 2*notifyLookback {
+			delete(delivered, key)
+			continue
+		}
+		fmt.Fprintf(&b, "%s\t%s\n", key, t.Format(time.RFC3339))
+	}
+	return writeFileAtomic(path, []byte(b.String()))
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestDueAlarms(t *testing.T) {
+	now := time.Date(2024, 10, 1, 12, 0, 0, 0, time.UTC)
+	before := Alarm{Trigger: "-PT15M", Related: "END", Offset: -15 * time.Minute}
+	todos := []*Todo{
+		{UID: "fired", Status: "NEEDS-ACTION", DueDate: now.Add(10 * time.Minute), Alarms: []Alarm{before}},
+		{UID: "future", Status: "NEEDS-ACTION", DueDate: now.Add(time.Hour), Alarms: []Alarm{before}},
+		{UID: "stale", Status: "NEEDS-ACTION", DueDate: now.Add(-48 * time.Hour), Alarms: []Alarm{before}},
+		{UID: "done", Status: "COMPLETED", DueDate: now, Alarms: []Alarm{before}},
+		{UID: "nodue", Status: "NEEDS-ACTION", Alarms: []Alarm{before}},
+	}
+	todoList := &TodoList{Todos: todos}
+	delivered := make(map[string]time.Time)
+
+	pending := dueAlarms(todoList, delivered, now)
+	if len(pending) != 1 || pending[0].Todo.UID != "fired" {
+		t.Fatalf("Expected only the fired alarm, got %v", pending)
+	}
+
+	delivered[pending[0].Key] = pending[0].Time
+	if pending := dueAlarms(todoList, delivered, now); len(pending) != 0 {
+		t.Errorf("Expected delivered alarm not to fire again, got %v", pending)
+	}
+}
+
+func TestDeliveredState(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "todocalmenu", "delivered")
+	now := time.Date(2024, 10, 1, 12, 0, 0, 0, time.UTC)
+	delivered := map[string]time.Time{
+		"recent": now.Add(-time.Hour),
+		"old":    now.Add(-7 * 24 * time.Hour),
+	}
+	if err := saveDelivered(path, delivered, now); err != nil {
+		t.Fatalf("Failed to save state: %v", err)
+	}
+	loaded, err := loadDelivered(path)
+	if err != nil {
+		t.Fatalf("Failed to load state: %v", err)
+	}
+	if len(loaded) != 1 || !loaded["recent"].Equal(now.Add(-time.Hour)) {
+		t.Errorf("Expected only the recent entry, got %v", loaded)
+	}
+	if files, _ := filepath.Glob(filepath.Join(filepath.Dir(path), "*")); len(files) != 1 {
+		t.Errorf("Expected only the state file, got %v", files)
+	}
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// applyQuickAdd parses todo.txt style tokens in a new todo's title and sets
+// the matching fields, leaving the rest as the summary:
+//
+//	(A) or !1       priority, A-I map to 1-9
+//	@cat or +cat    category
+//	due:fri         due date, anything parseDateInput accepts
+//	t:2024-10-01    start (threshold) date
+//	rec:1w          recurrence in d, w, m or y, or 1b for every business day
+//	?               review the item in the edit menu before adding
+//
+// Use _ for spaces in date values, e.g. due:next_monday. Tokens that don't
+// parse are left in the summary. It returns whether the user asked to
+// review.
+func applyQuickAdd(todo *Todo, input string, now time.Time) (review bool) {
+	var summary []string
+	for _, tok := range strings.Fields(input) {
+		if !applyQuickAddToken(todo, tok, now) {
+			if tok == "?" {
+				review = true
+				continue
+			}
+			summary = append(summary, tok)
+		}
+	}
+	todo.Summary = strings.Join(summary, " ")
+	return review
+}
+
+func applyQuickAddToken(todo *Todo, tok string, now time.Time) bool {
+	switch {
+	case len(tok) == 3 && tok[0] == '(' && tok[2] == ')' && tok[1] >= 'A' && tok[1] <= 'Z':
+		todo.Priority = min(int(tok[1]-'A')+1, 9)
+		return true
+	case len(tok) == 2 && tok[0] == '!' && tok[1] >= '1' && tok[1] <= '9':
+		todo.Priority = int(tok[1] - '0')
+		return true
+	case len(tok) > 1 && (tok[0] == '@' || tok[0] == '+') && !isDigit(tok[1]):
+		cat := tok[1:]
+		if !slices.Contains(todo.Categories, cat) {
+			todo.Categories = append(todo.Categories, cat)
+		}
+		return true
+	}
+
+	key, value, ok := strings.Cut(tok, ":")
+	if !ok || value == "" {
+		return false
+	}
+	value = strings.ReplaceAll(value, "_", " ")
+	switch strings.ToLower(key) {
+	case "due":
+		t, hasTime, err := parseDateInput(value, now)
+		if err != nil {
+			return false
+		}
+		todo.DueDate = t
+		todo.DueKind = DateOnly
+		if hasTime {
+			todo.DueKind = DateTimeUTC
+		}
+	case "t":
+		t, hasTime, err := parseDateInput(value, now)
+		if err != nil {
+			return false
+		}
+		todo.StartDate = t
+		todo.StartKind = DateOnly
+		if hasTime {
+			todo.StartKind = DateTimeUTC
+		}
+	case "rec":
+		rule, err := quickAddRRule(value)
+		if err != nil {
+			return false
+		}
+		todo.RRule = rule
+	default:
+		return false
+	}
+	return true
+}
+
+// quickAddRRule converts a todo.txt style recurrence such as 1w, +2d or 1b
+// to an RRULE.
+func quickAddRRule(value string) (string, error) {
+	value = strings.TrimPrefix(value, "+")
+	if len(value) < 2 {
+		return "", fmt.Errorf("invalid recurrence %q", value)
+	}
+	n, err := strconv.Atoi(value[:len(value)-1])
+	if err != nil || n < 1 {
+		return "", fmt.Errorf("invalid recurrence %q", value)
+	}
+	freq := map[byte]string{'d': "DAILY", 'w': "WEEKLY", 'm': "MONTHLY", 'y': "YEARLY"}
+	unit := value[len(value)-1]
+	if unit == 'b' {
+		// An RRULE can't count business days, so only every one works
+		if n > 1 {
+			return "", fmt.Errorf("only 1b is supported, not %q", value)
+		}
+		return "FREQ=WEEKLY;BYDAY=MO,TU,WE,TH,FR", nil
+	}
+	f, ok := freq[unit]
+	if !ok {
+		return "", fmt.Errorf("invalid recurrence unit %q", unit)
+	}
+	rule := "FREQ=" + f
+	if n > 1 {
+		rule += fmt.Sprintf(";INTERVAL=%d", n)
+	}
+	return rule, nil
+}
+
+func isDigit(b byte) bool {
+	return b >= '0' && b <= '9'
+}
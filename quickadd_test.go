@@ -0,0 +1,72 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestApplyQuickAdd(t *testing.T) {
+	// Wednesday
+	now := time.Date(2024, 9, 18, 10, 0, 0, 0, time.Local)
+	todo := &Todo{}
+	review := applyQuickAdd(todo, "(B) Call mom @phone +family due:fri t:2024-09-19 rec:1w", now)
+
+	if review {
+		t.Error("Expected no review")
+	}
+	if todo.Summary != "Call mom" {
+		t.Errorf("Expected summary 'Call mom', got %q", todo.Summary)
+	}
+	if todo.Priority != 2 {
+		t.Errorf("Expected priority 2, got %d", todo.Priority)
+	}
+	if !reflect.DeepEqual(todo.Categories, []string{"phone", "family"}) {
+		t.Errorf("Expected categories [phone family], got %v", todo.Categories)
+	}
+	if !todo.DueDate.Equal(time.Date(2024, 9, 20, 0, 0, 0, 0, time.Local)) || todo.DueKind != DateOnly {
+		t.Errorf("Expected all-day due 2024-09-20, got %v (kind %v)", todo.DueDate, todo.DueKind)
+	}
+	if !todo.StartDate.Equal(time.Date(2024, 9, 19, 0, 0, 0, 0, time.Local)) {
+		t.Errorf("Expected start 2024-09-19, got %v", todo.StartDate)
+	}
+	if todo.RRule != "FREQ=WEEKLY" {
+		t.Errorf("Expected weekly RRULE, got %q", todo.RRule)
+	}
+}
+
+func TestApplyQuickAddLeavesUnknownTokens(t *testing.T) {
+	now := time.Date(2024, 9, 18, 10, 0, 0, 0, time.Local)
+	todo := &Todo{}
+	review := applyQuickAdd(todo, "Read ch:3 due:someday +3 !1 ?", now)
+
+	if !review {
+		t.Error("Expected review to be requested")
+	}
+	if todo.Summary != "Read ch:3 due:someday +3" {
+		t.Errorf("Unexpected summary %q", todo.Summary)
+	}
+	if todo.Priority != 1 || !todo.DueDate.IsZero() || len(todo.Categories) != 0 {
+		t.Errorf("Unexpected fields %+v", todo)
+	}
+}
+
+func TestQuickAddRRule(t *testing.T) {
+	tests := map[string]string{
+		"1d":  "FREQ=DAILY",
+		"+2w": "FREQ=WEEKLY;INTERVAL=2",
+		"3m":  "FREQ=MONTHLY;INTERVAL=3",
+		"1y":  "FREQ=YEARLY",
+		"1b":  "FREQ=WEEKLY;BYDAY=MO,TU,WE,TH,FR",
+	}
+	for value, expected := range tests {
+		if rule, err := quickAddRRule(value); err != nil || rule != expected {
+			t.Errorf("quickAddRRule(%q) = %q, %v; expected %q", value, rule, err, expected)
+		}
+	}
+	for _, value := range []string{"w", "0d", "1x", "2b"} {
+		if _, err := quickAddRRule(value); err == nil {
+			t.Errorf("Expected error for %q", value)
+		}
+	}
+}
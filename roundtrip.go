@@ -0,0 +1,364 @@
+package main
+
+import (
+	"bytes"
+	"reflect"
+	"slices"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	ics "github.com/arran4/golang-ical"
+)
+
+// listProperties hold comma separated lists of TEXT values. The ics package
+// unescapes them as a single value, which loses the difference between a
+// separating comma and an escaped one, so their Value is kept escaped as it
+// is in the file.
+var listProperties = map[string]bool{
+	string(ics.PropertyCategories): true,
+	string(ics.PropertyResources):  true,
+}
+
+var textEscaper = strings.NewReplacer(`\`, `\\`, `;`, `\;`, `,`, `\,`, "\r\n", `\n`, "\n", `\n`, "\r", `\n`)
+
+// escapeText escapes a TEXT value (RFC 5545 3.3.11).
+func escapeText(s string) string {
+	return textEscaper.Replace(s)
+}
+
+// splitList splits an escaped list value into its unescaped items.
+func splitList(value string) []string {
+	var items []string
+	var item strings.Builder
+	for i := 0; i < len(value); i++ {
+		switch c := value[i]; {
+		case c == '\\' && i+1 < len(value):
+			i++
+			if value[i] == 'n' || value[i] == 'N' {
+				item.WriteByte('\n')
+			} else {
+				item.WriteByte(value[i])
+			}
+		case c == ',':
+			items = append(items, item.String())
+			item.Reset()
+		default:
+			item.WriteByte(c)
+		}
+	}
+	return append(items, item.String())
+}
+
+// joinList is the inverse of splitList.
+func joinList(items []string) string {
+	escaped := make([]string, len(items))
+	for i, item := range items {
+		escaped[i] = escapeText(item)
+	}
+	return strings.Join(escaped, ",")
+}
+
+// todoCategories merges the items of all CATEGORIES properties, dropping
+// blanks and duplicates.
+func todoCategories(vtodo *ics.VTodo) []string {
+	var categories []string
+	for _, prop := range vtodo.Properties {
+		if prop.IANAToken != string(ics.ComponentPropertyCategories) {
+			continue
+		}
+		for _, c := range splitList(prop.Value) {
+			if c = strings.TrimSpace(c); c != "" && !slices.Contains(categories, c) {
+				categories = append(categories, c)
+			}
+		}
+	}
+	return categories
+}
+
+// setCategories updates the CATEGORIES properties to hold categories. The
+// properties are left alone if nothing changed; otherwise removed categories
+// are taken out of the property they were in and new ones are added to the
+// last CATEGORIES property, so parameters and the split over several
+// properties are kept.
+func setCategories(vtodo *ics.VTodo, categories []string) {
+	if slices.Equal(todoCategories(vtodo), categories) {
+		return
+	}
+	var seen []string
+	last := -1
+	props := vtodo.Properties[:0]
+	for _, prop := range vtodo.Properties {
+		if prop.IANAToken != string(ics.ComponentPropertyCategories) {
+			props = append(props, prop)
+			continue
+		}
+		var items []string
+		for _, c := range splitList(prop.Value) {
+			c = strings.TrimSpace(c)
+			if slices.Contains(categories, c) && !slices.Contains(seen, c) {
+				items = append(items, c)
+				seen = append(seen, c)
+			}
+		}
+		if len(items) == 0 {
+			continue
+		}
+		prop.Value = joinList(items)
+		last = len(props)
+		props = append(props, prop)
+	}
+	vtodo.Properties = props
+
+	var added []string
+	for _, c := range categories {
+		if !slices.Contains(seen, c) {
+			added = append(added, c)
+		}
+	}
+	switch {
+	case len(added) == 0:
+	case last >= 0:
+		vtodo.Properties[last].Value += "," + joinList(added)
+	default:
+		vtodo.AddProperty(ics.ComponentPropertyCategories, joinList(added))
+	}
+}
+
+// componentBase returns the name and properties of a component.
+func componentBase(c ics.Component) (string, *ics.ComponentBase) {
+	switch c := c.(type) {
+	case *ics.VEvent:
+		return "VEVENT", &c.ComponentBase
+	case *ics.VTodo:
+		return "VTODO", &c.ComponentBase
+	case *ics.VJournal:
+		return "VJOURNAL", &c.ComponentBase
+	case *ics.VBusy:
+		return "VFREEBUSY", &c.ComponentBase
+	case *ics.VTimezone:
+		return "VTIMEZONE", &c.ComponentBase
+	case *ics.VAlarm:
+		return "VALARM", &c.ComponentBase
+	case *ics.Standard:
+		return "STANDARD", &c.ComponentBase
+	case *ics.Daylight:
+		return "DAYLIGHT", &c.ComponentBase
+	case *ics.GeneralComponent:
+		return strings.ToUpper(c.Token), &c.ComponentBase
+	}
+	return "", nil
+}
+
+// parseRawCalendar reads the lines of a file into a component tree, or
+// returns nil if it can't.
+func parseRawCalendar(data []byte) *icsComponent {
+	lines, _ := unfoldLines(data)
+	root, err := nestComponents(lines)
+	if err != nil {
+		return nil
+	}
+	return root
+}
+
+// keepListEscapes puts the escaped text of list properties back after the
+// ics package parsed data into cal.
+func keepListEscapes(cal *ics.Calendar, data []byte) {
+	root := parseRawCalendar(data)
+	if root == nil || len(root.components) != len(cal.Components) {
+		return
+	}
+	var walk func(c ics.Component, raw *icsComponent)
+	walk = func(c ics.Component, raw *icsComponent) {
+		_, base := componentBase(c)
+		if base == nil || len(base.Properties) != len(raw.props) || len(base.Components) != len(raw.components) {
+			return
+		}
+		for i := range base.Properties {
+			if listProperties[base.Properties[i].IANAToken] && raw.props[i].name == base.Properties[i].IANAToken {
+				base.Properties[i].Value = raw.props[i].value
+			}
+		}
+		for i, sub := range base.Components {
+			walk(sub, raw.components[i])
+		}
+	}
+	for i, c := range cal.Components {
+		walk(c, root.components[i])
+	}
+}
+
+// serializeCalendar writes cal in iCalendar format. Properties that are
+// unchanged from original, the file cal was read from, are copied from it
+// byte for byte, folding and all. Other properties are escaped and folded
+// as RFC 5545 asks.
+func serializeCalendar(cal *ics.Calendar, original []byte) []byte {
+	root := parseRawCalendar(original)
+	if root == nil {
+		root = &icsComponent{}
+	}
+	var b bytes.Buffer
+	b.WriteString("BEGIN:VCALENDAR\r\n")
+	props := make([]ics.BaseProperty, len(cal.CalendarProperties))
+	for i, p := range cal.CalendarProperties {
+		props[i] = p.BaseProperty
+	}
+	writeProperties(&b, props, root)
+	writeComponents(&b, cal.Components, root)
+	b.WriteString("END:VCALENDAR\r\n")
+	return b.Bytes()
+}
+
+func writeComponents(b *bytes.Buffer, components []ics.Component, raw *icsComponent) {
+	used := make([]bool, len(raw.components))
+	for _, c := range components {
+		name, base := componentBase(c)
+		if base == nil {
+			continue
+		}
+		match := &icsComponent{}
+		if i := matchComponent(raw, used, name, base); i >= 0 {
+			used[i] = true
+			match = raw.components[i]
+		}
+		b.WriteString("BEGIN:" + name + "\r\n")
+		props := make([]ics.BaseProperty, len(base.Properties))
+		for i, p := range base.Properties {
+			props[i] = p.BaseProperty
+		}
+		writeProperties(b, props, match)
+		writeComponents(b, base.Components, match)
+		b.WriteString("END:" + name + "\r\n")
+	}
+}
+
+// matchComponent finds the component of raw that c was read from: the one
+// with the same UID, otherwise the one of the same kind sharing the most
+// properties with c. It returns -1 if there is none.
+func matchComponent(raw *icsComponent, used []bool, name string, c *ics.ComponentBase) int {
+	uid := ""
+	if p := c.GetProperty(ics.ComponentPropertyUniqueId); p != nil {
+		uid = p.Value
+	}
+	best, bestScore := -1, -1
+	for i, candidate := range raw.components {
+		if used[i] || candidate.name != name {
+			continue
+		}
+		if uid != "" {
+			if p, ok := candidate.get("UID"); ok && ics.FromText(p.value) == uid {
+				return i
+			}
+		}
+		score := 0
+		for _, p := range c.Properties {
+			if findRawProperty(candidate, nil, p.BaseProperty) >= 0 {
+				score++
+			}
+		}
+		if score > bestScore {
+			best, bestScore = i, score
+		}
+	}
+	if uid != "" && best >= 0 {
+		// A component with a UID only matches the same UID
+		if p, ok := raw.components[best].get("UID"); ok && ics.FromText(p.value) != uid {
+			return -1
+		}
+	}
+	return best
+}
+
+func writeProperties(b *bytes.Buffer, props []ics.BaseProperty, raw *icsComponent) {
+	used := make([]bool, len(raw.props))
+	for _, p := range props {
+		if i := findRawProperty(raw, used, p); i >= 0 {
+			used[i] = true
+			b.WriteString(raw.props[i].raw)
+			continue
+		}
+		b.WriteString(formatProperty(p))
+	}
+}
+
+// findRawProperty returns the index of an unused line in raw that reads as
+// p, or -1. Lines that aren't CRLF terminated or are too long never match,
+// so they are written again properly.
+func findRawProperty(raw *icsComponent, used []bool, p ics.BaseProperty) int {
+	for i, line := range raw.props {
+		if (used != nil && used[i]) || !line.valid || !strings.EqualFold(line.name, p.IANAToken) {
+			continue
+		}
+		read, err := ics.ParseProperty(ics.ContentLine(line.text))
+		if err != nil || read == nil {
+			continue
+		}
+		if listProperties[read.IANAToken] {
+			read.Value = line.value
+		}
+		if read.IANAToken == p.IANAToken && read.Value == p.Value && sameParams(read.ICalParameters, p.ICalParameters) {
+			return i
+		}
+	}
+	return -1
+}
+
+func sameParams(a, b map[string][]string) bool {
+	if len(a) == 0 && len(b) == 0 {
+		return true
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+// formatProperty writes a property as a folded content line. Parameter
+// values with special characters are quoted and TEXT values are escaped.
+func formatProperty(p ics.BaseProperty) string {
+	var line strings.Builder
+	line.WriteString(p.IANAToken)
+	keys := make([]string, 0, len(p.ICalParameters))
+	for k := range p.ICalParameters {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		line.WriteString(";" + k + "=")
+		for i, v := range p.ICalParameters[k] {
+			if i > 0 {
+				line.WriteString(",")
+			}
+			v = strings.NewReplacer(`"`, "", "\r", "", "\n", " ").Replace(v)
+			if strings.ContainsAny(v, ";:,") {
+				v = `"` + v + `"`
+			}
+			line.WriteString(v)
+		}
+	}
+	line.WriteString(":")
+	switch {
+	case listProperties[p.IANAToken]:
+		line.WriteString(p.Value)
+	case p.GetValueType() == ics.ValueDataTypeText:
+		line.WriteString(escapeText(p.Value))
+	default:
+		line.WriteString(p.Value)
+	}
+	return foldLine(line.String())
+}
+
+// foldLine splits a content line into lines of at most 75 octets, without
+// breaking up UTF-8 characters, and ends it with CRLF.
+func foldLine(s string) string {
+	var b strings.Builder
+	limit := 75
+	for len(s) > limit {
+		n := limit
+		for n > 0 && !utf8.RuneStart(s[n]) {
+			n--
+		}
+		b.WriteString(s[:n] + "\r\n ")
+		s = s[n:]
+		limit = 74
+	}
+	b.WriteString(s + "\r\n")
+	return b.String()
+}
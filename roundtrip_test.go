@@ -0,0 +1,185 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	ics "github.com/arran4/golang-ical"
+)
+
+var update = flag.Bool("update", false, "rewrite the .golden files in testdata/roundtrip")
+
+// roundtripEdits are the edits made to each export in testdata/roundtrip
+// before comparing the saved file to its .golden file.
+var roundtripEdits = map[string]func(todo *Todo){
+	"nextcloud": func(todo *Todo) {
+		todo.Summary = "Plan team offsite, Q2; Berlin"
+		todo.Categories = []string{"Planning, Q2", "Travel"}
+	},
+	"tasksorg": func(todo *Todo) {
+		todo.Summary = "Renew car insurance\nand the breakdown cover"
+		todo.Priority = 2
+		todo.Categories = []string{"Admin", "Money", "Insurance, car"}
+	},
+	"thunderbird": func(todo *Todo) {
+		setStatus(todo, "COMPLETED", time.Date(2024, 5, 2, 14, 30, 0, 0, time.UTC))
+	},
+}
+
+// dtstampRe matches the DTSTAMP written on every save.
+var dtstampRe = regexp.MustCompile(`DTSTAMP:\d{8}T\d{6}Z`)
+
+// loadExport copies an export into a temporary directory and loads it.
+func loadExport(t *testing.T, name string) (string, *TodoList, []byte) {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join("testdata", "roundtrip", name+".ics"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, name+".ics"), data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	todoList := mustLoad(t, dir)
+	if len(todoList.Todos) != 1 {
+		t.Fatalf("Expected one todo in %s, got %d", name, len(todoList.Todos))
+	}
+	return dir, todoList, data
+}
+
+func saveExport(t *testing.T, dir string, todoList *TodoList) []byte {
+	t.Helper()
+	todoList.Todos[0].Modified = true
+	if err := saveTodos(todoList, dir); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(filepath.Join(dir, todoList.Todos[0].FileName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+// TestRoundtripUnchanged saves each export without changes. Everything but
+// DTSTAMP and SEQUENCE must come back byte for byte.
+func TestRoundtripUnchanged(t *testing.T) {
+	strip := func(data []byte) string {
+		var kept []string
+		for _, line := range strings.SplitAfter(string(data), "\r\n") {
+			if !strings.HasPrefix(line, "DTSTAMP:") && !strings.HasPrefix(line, "SEQUENCE:") {
+				kept = append(kept, line)
+			}
+		}
+		return strings.Join(kept, "")
+	}
+	for name := range roundtripEdits {
+		dir, todoList, original := loadExport(t, name)
+		saved := saveExport(t, dir, todoList)
+		if strip(saved) != strip(original) {
+			t.Errorf("%s changed when saved unmodified:\n%s", name, saved)
+		}
+	}
+}
+
+// TestRoundtripGolden edits each export and compares the saved file with
+// its .golden file. Run with -update to rewrite them.
+func TestRoundtripGolden(t *testing.T) {
+	for name, edit := range roundtripEdits {
+		dir, todoList, _ := loadExport(t, name)
+		todo := todoList.Todos[0]
+		edit(todo)
+		todo.LastMod = time.Date(2024, 5, 2, 14, 30, 0, 0, time.UTC)
+		saved := dtstampRe.ReplaceAll(saveExport(t, dir, todoList), []byte("DTSTAMP:20240502T143000Z"))
+
+		golden := filepath.Join("testdata", "roundtrip", name+".golden")
+		if *update {
+			if err := os.WriteFile(golden, saved, 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		want, err := os.ReadFile(golden)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(saved) != string(want) {
+			t.Errorf("%s doesn't match %s:\n%s", name, golden, saved)
+		}
+
+		// And it reads back the same
+		loaded := mustLoad(t, dir).Todos[0]
+		if loaded.Summary != todo.Summary || !reflect.DeepEqual(loaded.Categories, todo.Categories) {
+			t.Errorf("%s: expected %q %q after reloading, got %q %q", name,
+				todo.Summary, todo.Categories, loaded.Summary, loaded.Categories)
+		}
+	}
+}
+
+func TestSplitList(t *testing.T) {
+	items := splitList(`Work,Planning\, Q2,a\\b,semi\;colon`)
+	want := []string{"Work", "Planning, Q2", `a\b`, "semi;colon"}
+	if !reflect.DeepEqual(items, want) {
+		t.Errorf("Expected %q, got %q", want, items)
+	}
+	if got := joinList(want); got != `Work,Planning\, Q2,a\\b,semi\;colon` {
+		t.Errorf("Expected the list escaped again, got %q", got)
+	}
+}
+
+func TestSetCategories(t *testing.T) {
+	vtodo := ics.NewCalendar().AddTodo("a")
+	vtodo.AddProperty(ics.ComponentPropertyCategories, "home,garden")
+	vtodo.AddProperty(ics.ComponentPropertyCategories, `work\, misc`, &ics.KeyValues{Key: "LANGUAGE", Value: []string{"en"}})
+
+	if got := todoCategories(vtodo); !reflect.DeepEqual(got, []string{"home", "garden", "work, misc"}) {
+		t.Fatalf("Expected the properties merged, got %q", got)
+	}
+	setCategories(vtodo, []string{"garden", "work, misc", "new"})
+	var values []string
+	for _, p := range vtodo.Properties {
+		if p.IANAToken == "CATEGORIES" {
+			values = append(values, p.Value)
+		}
+	}
+	if !reflect.DeepEqual(values, []string{"garden", `work\, misc,new`}) {
+		t.Errorf("Expected the categories updated in place, got %q", values)
+	}
+	if lang := vtodo.Properties[len(vtodo.Properties)-1].ICalParameters["LANGUAGE"]; len(lang) != 1 {
+		t.Error("Expected LANGUAGE to be kept")
+	}
+	setCategories(vtodo, nil)
+	if todoCategories(vtodo) != nil {
+		t.Errorf("Expected no categories left, got %q", todoCategories(vtodo))
+	}
+}
+
+func TestFoldLine(t *testing.T) {
+	line := "DESCRIPTION:" + strings.Repeat("ä", 60)
+	folded := foldLine(line)
+	for _, l := range strings.Split(strings.TrimSuffix(folded, "\r\n"), "\r\n") {
+		if len(l) > 75 || !utf8.ValidString(l) {
+			t.Errorf("Bad folded line %q", l)
+		}
+	}
+	if unfolded := strings.ReplaceAll(folded, "\r\n ", ""); unfolded != line+"\r\n" {
+		t.Errorf("Expected folding to be undone by unfolding, got %q", unfolded)
+	}
+}
+
+func TestFormatProperty(t *testing.T) {
+	p := ics.BaseProperty{
+		IANAToken:      "DESCRIPTION",
+		Value:          "Call Bo; bring keys, cards\r\nand a pen",
+		ICalParameters: map[string][]string{"ALTREP": {"cid:part1@example.org"}, "LANGUAGE": {"en"}},
+	}
+	want := `DESCRIPTION;ALTREP="cid:part1@example.org";LANGUAGE=en:Call Bo\; bring keys` + "\r\n " + `\, cards\nand a pen` + "\r\n"
+	if got := formatProperty(p); got != want {
+		t.Errorf("Expected %q, got %q", want, got)
+	}
+}
@@ -0,0 +1,449 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// RRule is the subset of an RFC 5545 recurrence rule that is needed to
+// advance recurring todos. Unknown parts are kept so they survive a rewrite.
+type RRule struct {
+	Freq       string // DAILY, WEEKLY, MONTHLY, YEARLY, HOURLY, MINUTELY
+	Interval   int
+	Count      int
+	Until      time.Time
+	ByDay      []string // e.g. TU, 1MO, -1FR
+	ByMonthDay []int
+	ByMonth    []int
+	WeekStart  time.Weekday
+	until      string   // Original UNTIL value, written back unchanged
+	wkst       string   // Original WKST value
+	extra      []string // Unsupported parts, written back unchanged
+}
+
+var weekdays = map[string]time.Weekday{
+	"SU": time.Sunday,
+	"MO": time.Monday,
+	"TU": time.Tuesday,
+	"WE": time.Wednesday,
+	"TH": time.Thursday,
+	"FR": time.Friday,
+	"SA": time.Saturday,
+}
+
+// Recurrence presets offered in the edit menu
+var rrulePresets = []struct {
+	Name string
+	Rule string
+}{
+	{"Daily", "FREQ=DAILY"},
+	{"Weekdays", "FREQ=WEEKLY;BYDAY=MO,TU,WE,TH,FR"},
+	{"Weekly", "FREQ=WEEKLY"},
+	{"Every 2 weeks", "FREQ=WEEKLY;INTERVAL=2"},
+	{"Monthly", "FREQ=MONTHLY"},
+	{"Yearly", "FREQ=YEARLY"},
+}
+
+func parseRRule(value string) (*RRule, error) {
+	r := &RRule{Interval: 1, WeekStart: time.Monday}
+	for _, part := range strings.Split(strings.TrimSpace(value), ";") {
+		if part == "" {
+			continue
+		}
+		kv := strings.SplitN(part, "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("invalid rule part %q", part)
+		}
+		key, val := strings.ToUpper(kv[0]), kv[1]
+		var err error
+		switch key {
+		case "FREQ":
+			r.Freq = strings.ToUpper(val)
+		case "INTERVAL":
+			r.Interval, err = strconv.Atoi(val)
+			if err == nil && r.Interval < 1 {
+				err = fmt.Errorf("interval must be positive")
+			}
+		case "COUNT":
+			r.Count, err = strconv.Atoi(val)
+		case "UNTIL":
+			r.until = val
+			r.Until = parseDateTime(val)
+			if r.Until.IsZero() {
+				err = fmt.Errorf("invalid UNTIL %q", val)
+			}
+		case "BYDAY":
+			for _, d := range strings.Split(strings.ToUpper(val), ",") {
+				if _, _, ok := parseByDay(d); !ok {
+					return nil, fmt.Errorf("invalid BYDAY %q", d)
+				}
+				r.ByDay = append(r.ByDay, d)
+			}
+		case "BYMONTHDAY":
+			r.ByMonthDay, err = parseIntList(val)
+		case "BYMONTH":
+			r.ByMonth, err = parseIntList(val)
+		case "WKST":
+			wd, ok := weekdays[strings.ToUpper(val)]
+			if !ok {
+				err = fmt.Errorf("invalid WKST %q", val)
+			}
+			r.WeekStart = wd
+			r.wkst = val
+		default:
+			r.extra = append(r.extra, part)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s: %v", key, err)
+		}
+	}
+	switch r.Freq {
+	case "DAILY", "WEEKLY", "MONTHLY", "YEARLY", "HOURLY", "MINUTELY":
+	case "":
+		return nil, fmt.Errorf("missing FREQ")
+	default:
+		return nil, fmt.Errorf("unsupported FREQ %q", r.Freq)
+	}
+	return r, nil
+}
+
+func parseIntList(value string) ([]int, error) {
+	var list []int
+	for _, s := range strings.Split(value, ",") {
+		n, err := strconv.Atoi(s)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, n)
+	}
+	return list, nil
+}
+
+// parseByDay splits a BYDAY entry like "-1FR" into its ordinal and weekday.
+func parseByDay(value string) (int, time.Weekday, bool) {
+	if len(value) < 2 {
+		return 0, 0, false
+	}
+	wd, ok := weekdays[value[len(value)-2:]]
+	if !ok {
+		return 0, 0, false
+	}
+	n := 0
+	if prefix := value[:len(value)-2]; prefix != "" {
+		var err error
+		if n, err = strconv.Atoi(prefix); err != nil || n == 0 {
+			return 0, 0, false
+		}
+	}
+	return n, wd, true
+}
+
+func (r *RRule) String() string {
+	parts := []string{"FREQ=" + r.Freq}
+	if r.Interval > 1 {
+		parts = append(parts, fmt.Sprintf("INTERVAL=%d", r.Interval))
+	}
+	if r.Count > 0 {
+		parts = append(parts, fmt.Sprintf("COUNT=%d", r.Count))
+	}
+	if r.until != "" {
+		parts = append(parts, "UNTIL="+r.until)
+	}
+	if len(r.ByDay) > 0 {
+		parts = append(parts, "BYDAY="+strings.Join(r.ByDay, ","))
+	}
+	if len(r.ByMonthDay) > 0 {
+		parts = append(parts, "BYMONTHDAY="+joinInts(r.ByMonthDay))
+	}
+	if len(r.ByMonth) > 0 {
+		parts = append(parts, "BYMONTH="+joinInts(r.ByMonth))
+	}
+	if r.wkst != "" {
+		parts = append(parts, "WKST="+r.wkst)
+	}
+	parts = append(parts, r.extra...)
+	return strings.Join(parts, ";")
+}
+
+func joinInts(list []int) string {
+	s := make([]string, len(list))
+	for i, n := range list {
+		s[i] = strconv.Itoa(n)
+	}
+	return strings.Join(s, ",")
+}
+
+// Summary returns a short human readable description such as
+// "every 2 weeks on Tu".
+func (r *RRule) Summary() string {
+	units := map[string]string{
+		"DAILY":    "day",
+		"WEEKLY":   "week",
+		"MONTHLY":  "month",
+		"YEARLY":   "year",
+		"HOURLY":   "hour",
+		"MINUTELY": "minute",
+	}
+	var s string
+	if r.Interval > 1 {
+		s = fmt.Sprintf("every %d %ss", r.Interval, units[r.Freq])
+	} else {
+		s = strings.ToLower(r.Freq)
+	}
+	if len(r.ByDay) > 0 {
+		days := make([]string, len(r.ByDay))
+		for i, d := range r.ByDay {
+			days[i] = d[:len(d)-2] + strings.ToUpper(d[len(d)-2:len(d)-1]) + strings.ToLower(d[len(d)-1:])
+		}
+		s += " on " + strings.Join(days, ",")
+	}
+	if len(r.ByMonthDay) > 0 {
+		s += " on day " + joinInts(r.ByMonthDay)
+	}
+	if r.Count > 0 {
+		s += fmt.Sprintf(" (%d times)", r.Count)
+	}
+	if !r.Until.IsZero() {
+		s += " until " + r.Until.Format("2006-01-02")
+	}
+	return s
+}
+
+// Next returns the first occurrence strictly after base that is not listed
+// in exdates. The rule is anchored at base, the way Tasks.org advances a
+// recurring task from its current due date. ok is false when the series has
+// no further occurrences.
+func (r *RRule) Next(base time.Time, exdates []time.Time) (next time.Time, ok bool) {
+	const maxPeriods = 1000
+	for k := 0; k < maxPeriods; k++ {
+		candidates := r.expand(base, k)
+		sort.Slice(candidates, func(i, j int) bool { return candidates[i].Before(candidates[j]) })
+		for _, c := range candidates {
+			if !c.After(base) {
+				continue
+			}
+			if !r.Until.IsZero() && c.After(r.Until) {
+				return time.Time{}, false
+			}
+			if containsTime(exdates, c) {
+				continue
+			}
+			return c, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// expand returns the occurrences in the k-th period after the one containing
+// base.
+func (r *RRule) expand(base time.Time, k int) []time.Time {
+	loc := base.Location()
+	h, mi, s := base.Clock()
+	at := func(y int, m time.Month, d int) time.Time {
+		return time.Date(y, m, d, h, mi, s, 0, loc)
+	}
+	n := k * r.Interval
+
+	var candidates []time.Time
+	switch r.Freq {
+	case "MINUTELY":
+		return []time.Time{base.Add(time.Duration(n) * time.Minute)}
+	case "HOURLY":
+		return []time.Time{base.Add(time.Duration(n) * time.Hour)}
+	case "DAILY":
+		day := at(base.Year(), base.Month(), base.Day()+n)
+		if r.matchesWeekday(day) && r.matchesMonthDay(day) {
+			candidates = append(candidates, day)
+		}
+	case "WEEKLY":
+		offset := (int(base.Weekday()) - int(r.WeekStart) + 7) % 7
+		weekStart := at(base.Year(), base.Month(), base.Day()-offset+7*n)
+		if len(r.ByDay) == 0 {
+			candidates = append(candidates, at(weekStart.Year(), weekStart.Month(), weekStart.Day()+offset))
+		}
+		for i := 0; i < 7 && len(r.ByDay) > 0; i++ {
+			day := at(weekStart.Year(), weekStart.Month(), weekStart.Day()+i)
+			if r.matchesWeekday(day) {
+				candidates = append(candidates, day)
+			}
+		}
+	case "MONTHLY":
+		first := time.Date(base.Year(), base.Month()+time.Month(n), 1, 0, 0, 0, 0, loc)
+		candidates = r.expandMonth(first.Year(), first.Month(), base.Day(), at)
+	case "YEARLY":
+		months := r.ByMonth
+		if len(months) == 0 {
+			months = []int{int(base.Month())}
+		}
+		for _, m := range months {
+			candidates = append(candidates, r.expandMonth(base.Year()+n, time.Month(m), base.Day(), at)...)
+		}
+		return candidates
+	}
+
+	// BYMONTH limits the other frequencies
+	var filtered []time.Time
+	for _, c := range candidates {
+		if len(r.ByMonth) == 0 || containsInt(r.ByMonth, int(c.Month())) {
+			filtered = append(filtered, c)
+		}
+	}
+	return filtered
+}
+
+// expandMonth returns the days of a month selected by BYMONTHDAY and BYDAY,
+// or the given default day when neither is set. Days that don't exist in the
+// month are skipped as required by RFC 5545.
+func (r *RRule) expandMonth(year int, month time.Month, defaultDay int, at func(int, time.Month, int) time.Time) []time.Time {
+	last := time.Date(year, month+1, 0, 0, 0, 0, 0, time.UTC).Day()
+	var days []time.Time
+	for d := 1; d <= last; d++ {
+		day := at(year, month, d)
+		switch {
+		case len(r.ByMonthDay) == 0 && len(r.ByDay) == 0:
+			if d != defaultDay {
+				continue
+			}
+		case len(r.ByMonthDay) > 0 && !r.matchesMonthDay(day):
+			continue
+		case len(r.ByDay) > 0 && !r.matchesMonthWeekday(day, last):
+			continue
+		}
+		days = append(days, day)
+	}
+	return days
+}
+
+func (r *RRule) matchesWeekday(t time.Time) bool {
+	if len(r.ByDay) == 0 {
+		return true
+	}
+	for _, d := range r.ByDay {
+		if _, wd, _ := parseByDay(d); wd == t.Weekday() {
+			return true
+		}
+	}
+	return false
+}
+
+// matchesMonthWeekday checks BYDAY entries with an optional ordinal, e.g.
+// 2TU for the second Tuesday or -1FR for the last Friday of the month.
+func (r *RRule) matchesMonthWeekday(t time.Time, daysInMonth int) bool {
+	for _, d := range r.ByDay {
+		n, wd, _ := parseByDay(d)
+		if wd != t.Weekday() {
+			continue
+		}
+		switch {
+		case n == 0:
+			return true
+		case n > 0 && (t.Day()-1)/7+1 == n:
+			return true
+		case n < 0 && (daysInMonth-t.Day())/7+1 == -n:
+			return true
+		}
+	}
+	return false
+}
+
+func (r *RRule) matchesMonthDay(t time.Time) bool {
+	if len(r.ByMonthDay) == 0 {
+		return true
+	}
+	last := time.Date(t.Year(), t.Month()+1, 0, 0, 0, 0, 0, time.UTC).Day()
+	for _, d := range r.ByMonthDay {
+		if d == t.Day() || (d < 0 && last+d+1 == t.Day()) {
+			return true
+		}
+	}
+	return false
+}
+
+func containsInt(list []int, n int) bool {
+	for _, v := range list {
+		if v == n {
+			return true
+		}
+	}
+	return false
+}
+
+func containsTime(list []time.Time, t time.Time) bool {
+	for _, v := range list {
+		if v.Equal(t) {
+			return true
+		}
+	}
+	return false
+}
+
+// advanceRecurrence moves a recurring todo to its next occurrence instead of
+// completing it. DUE is set to the next instance and DTSTART is shifted by
+// the same amount. RDATEs earlier than the next rule instance take
+// precedence. It returns false if the todo doesn't recur or the series is
+// finished, in which case the caller should complete it normally.
+func advanceRecurrence(todo *Todo) bool {
+	if todo.RRule == "" {
+		return false
+	}
+	rule, err := parseRRule(todo.RRule)
+	if err != nil {
+		return false
+	}
+	base, kind, tzid := todo.DueDate, todo.DueKind, todo.DueTZID
+	if base.IsZero() {
+		base, kind, tzid = todo.StartDate, todo.StartKind, todo.StartTZID
+	}
+	if base.IsZero() {
+		return false
+	}
+	// Expand in the todo's own time zone so it keeps its wall clock time
+	// across DST changes
+	base = base.In(recurrenceLocation(kind, tzid))
+	if rule.Count == 1 {
+		return false // Last occurrence
+	}
+
+	next, ok := rule.Next(base, todo.ExDates)
+	for _, rdate := range todo.RDates {
+		if rdate.After(base) && !containsTime(todo.ExDates, rdate) && (!ok || rdate.Before(next)) {
+			next, ok = rdate, true
+		}
+	}
+	if !ok {
+		return false
+	}
+	next = next.Local() // Todo times are kept in local time
+
+	if rule.Count > 1 {
+		rule.Count--
+		todo.RRule = rule.String()
+	}
+	if !todo.DueDate.IsZero() {
+		if !todo.StartDate.IsZero() {
+			// Keep the same lead time between start and due
+			todo.StartDate = next.Add(todo.StartDate.Sub(todo.DueDate))
+		}
+		todo.DueDate = next
+	} else {
+		todo.StartDate = next
+	}
+	return true
+}
+
+// recurrenceLocation returns the time zone occurrences of a DTSTART or DUE
+// of the given form are expanded in.
+func recurrenceLocation(kind DateKind, tzid string) *time.Location {
+	switch kind {
+	case DateTimeUTC:
+		return time.UTC
+	case DateTimeZoned:
+		if loc, err := time.LoadLocation(tzid); err == nil {
+			return loc
+		}
+	}
+	return time.Local
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRRuleNext(t *testing.T) {
+	date := func(y int, m time.Month, d int) time.Time {
+		return time.Date(y, m, d, 10, 0, 0, 0, time.UTC)
+	}
+	tests := []struct {
+		rule     string
+		base     time.Time
+		exdates  []time.Time
+		expected time.Time
+	}{
+		{"FREQ=DAILY;INTERVAL=3", date(2024, 10, 1), nil, date(2024, 10, 4)},
+		{"FREQ=DAILY", date(2024, 10, 1), []time.Time{date(2024, 10, 2)}, date(2024, 10, 3)},
+		{"FREQ=WEEKLY;INTERVAL=2;BYDAY=TU", date(2024, 10, 1), nil, date(2024, 10, 15)},
+		{"FREQ=WEEKLY;BYDAY=MO,WE,FR", date(2024, 10, 7), nil, date(2024, 10, 9)},
+		{"FREQ=WEEKLY;BYDAY=MO,WE,FR", date(2024, 10, 11), nil, date(2024, 10, 14)},
+		{"FREQ=MONTHLY", date(2024, 1, 31), nil, date(2024, 3, 31)},
+		{"FREQ=MONTHLY;BYDAY=-1FR", date(2024, 10, 25), nil, date(2024, 11, 29)},
+		{"FREQ=MONTHLY;BYMONTHDAY=1,15", date(2024, 10, 1), nil, date(2024, 10, 15)},
+		{"FREQ=YEARLY", date(2024, 2, 29), nil, date(2028, 2, 29)},
+	}
+	for _, tt := range tests {
+		rule, err := parseRRule(tt.rule)
+		if err != nil {
+			t.Fatalf("parseRRule(%q) failed: %v", tt.rule, err)
+		}
+		next, ok := rule.Next(tt.base, tt.exdates)
+		if !ok {
+			t.Errorf("%s: expected next occurrence, got none", tt.rule)
+			continue
+		}
+		if !next.Equal(tt.expected) {
+			t.Errorf("%s: expected %v, got %v", tt.rule, tt.expected, next)
+		}
+	}
+}
+
+func TestRRuleUntil(t *testing.T) {
+	rule, err := parseRRule("FREQ=DAILY;UNTIL=20241001T235959Z")
+	if err != nil {
+		t.Fatalf("parseRRule failed: %v", err)
+	}
+	base := time.Date(2024, 10, 1, 10, 0, 0, 0, time.UTC)
+	if next, ok := rule.Next(base, nil); ok {
+		t.Errorf("Expected no more occurrences, got %v", next)
+	}
+}
+
+func TestParseRRuleErrors(t *testing.T) {
+	for _, rule := range []string{"", "INTERVAL=2", "FREQ=SOMETIMES", "FREQ=DAILY;INTERVAL=0", "FREQ=WEEKLY;BYDAY=XX"} {
+		if _, err := parseRRule(rule); err == nil {
+			t.Errorf("Expected error for %q", rule)
+		}
+	}
+}
+
+func TestRRuleStringAndSummary(t *testing.T) {
+	rule, err := parseRRule("FREQ=WEEKLY;INTERVAL=2;BYDAY=TU;X-FOO=bar")
+	if err != nil {
+		t.Fatalf("parseRRule failed: %v", err)
+	}
+	if s := rule.String(); s != "FREQ=WEEKLY;INTERVAL=2;BYDAY=TU;X-FOO=bar" {
+		t.Errorf("Unexpected rule string %q", s)
+	}
+	if s := rule.Summary(); s != "every 2 weeks on Tu" {
+		t.Errorf("Unexpected summary %q", s)
+	}
+}
+
+func TestAdvanceRecurrence(t *testing.T) {
+	todo := &Todo{
+		RRule:     "FREQ=DAILY;COUNT=3",
+		DueDate:   time.Date(2024, 10, 1, 17, 0, 0, 0, time.UTC),
+		StartDate: time.Date(2024, 10, 1, 9, 0, 0, 0, time.UTC),
+	}
+	if !advanceRecurrence(todo) {
+		t.Fatal("Expected recurring todo to advance")
+	}
+	if !todo.DueDate.Equal(time.Date(2024, 10, 2, 17, 0, 0, 0, time.UTC)) {
+		t.Errorf("Unexpected due date %v", todo.DueDate)
+	}
+	if !todo.StartDate.Equal(time.Date(2024, 10, 2, 9, 0, 0, 0, time.UTC)) {
+		t.Errorf("Unexpected start date %v", todo.StartDate)
+	}
+	if todo.RRule != "FREQ=DAILY;COUNT=2" {
+		t.Errorf("Expected COUNT to be decremented, got %q", todo.RRule)
+	}
+
+	todo.RRule = "FREQ=DAILY;COUNT=1"
+	if advanceRecurrence(todo) {
+		t.Error("Expected last occurrence not to advance")
+	}
+	if advanceRecurrence(&Todo{DueDate: todo.DueDate}) {
+		t.Error("Expected non-recurring todo not to advance")
+	}
+}
+
+func TestAdvanceRecurrenceAcrossDST(t *testing.T) {
+	newYork, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skip(err)
+	}
+	// Expanding in Local instead would move the time by an hour
+	defer func(old *time.Location) { time.Local = old }(time.Local)
+	time.Local = time.FixedZone("UTC+9", 9*3600)
+
+	// DST starts in New York on 2024-03-10
+	todo := &Todo{
+		RRule:   "FREQ=DAILY",
+		DueDate: time.Date(2024, 3, 9, 9, 0, 0, 0, newYork).Local(),
+		DueKind: DateTimeZoned,
+		DueTZID: "America/New_York",
+	}
+	if !advanceRecurrence(todo) {
+		t.Fatal("Expected recurring todo to advance")
+	}
+	if want := time.Date(2024, 3, 10, 9, 0, 0, 0, newYork); !todo.DueDate.Equal(want) {
+		t.Errorf("Expected %v, got %v", want, todo.DueDate.In(newYork))
+	}
+}
+
+func TestLoadRecurringTodo(t *testing.T) {
+	todoList, err := loadTodos("testdata")
+	if err != nil {
+		t.Fatalf("Failed to load todos: %v", err)
+	}
+	todo := findTodoByUID(todoList, "3900172495289256706")
+	if todo == nil {
+		t.Fatal("Todo with UID 3900172495289256706 not found")
+	}
+	if todo.RRule != "FREQ=WEEKLY;INTERVAL=2;BYDAY=TU" {
+		t.Errorf("Unexpected RRULE %q", todo.RRule)
+	}
+}
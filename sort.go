@@ -0,0 +1,236 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// sortKey is one key of the -sort order.
+type sortKey struct {
+	name string
+	desc bool
+}
+
+// defaultSort is the order used when -sort isn't set.
+const defaultSort = "due,priority,-created"
+
+// sortOrder is the parsed -sort flag.
+var sortOrder = mustParseSort(defaultSort)
+
+// todoCompare compares two todos by one key, returning <0, 0 or >0 for the
+// ascending order. Unset values are handled by the caller.
+var todoCompare = map[string]func(a, b *Todo) int{
+	"priority": func(a, b *Todo) int { return a.Priority - b.Priority },
+	"due":      func(a, b *Todo) int { return a.DueDate.Compare(b.DueDate) },
+	"start":    func(a, b *Todo) int { return a.StartDate.Compare(b.StartDate) },
+	"created":  func(a, b *Todo) int { return a.Created.Compare(b.Created) },
+	"modified": func(a, b *Todo) int { return a.LastMod.Compare(b.LastMod) },
+	"summary": func(a, b *Todo) int {
+		return strings.Compare(strings.ToLower(a.Summary), strings.ToLower(b.Summary))
+	},
+	"category": func(a, b *Todo) int {
+		return strings.Compare(strings.ToLower(firstCategory(a)), strings.ToLower(firstCategory(b)))
+	},
+	"list": func(a, b *Todo) int { return strings.Compare(listName(a), listName(b)) },
+}
+
+// sortAliases are other names accepted in -sort.
+var sortAliases = map[string]string{
+	"pri":           "priority",
+	"last-modified": "modified",
+	"alpha":         "summary",
+	"title":         "summary",
+	"cat":           "category",
+}
+
+// isUnset reports whether the todo has no value for a sort key. Unset
+// values sort last in either direction.
+func isUnset(key string, todo *Todo) bool {
+	switch key {
+	case "priority":
+		return todo.Priority == 0
+	case "due":
+		return todo.DueDate.IsZero()
+	case "start":
+		return todo.StartDate.IsZero()
+	case "created":
+		return todo.Created.IsZero()
+	case "modified":
+		return todo.LastMod.IsZero()
+	case "category":
+		return len(todo.Categories) == 0
+	case "list":
+		return todo.Collection == nil
+	}
+	return false
+}
+
+// parseSort parses a comma separated list of sort keys, each optionally
+// prefixed with - for descending order.
+func parseSort(spec string) ([]sortKey, error) {
+	var keys []sortKey
+	for _, field := range strings.Split(spec, ",") {
+		field = strings.ToLower(strings.TrimSpace(field))
+		if field == "" {
+			continue
+		}
+		key := sortKey{}
+		if name, ok := strings.CutPrefix(field, "-"); ok {
+			field, key.desc = name, true
+		} else {
+			field = strings.TrimPrefix(field, "+")
+		}
+		if alias, ok := sortAliases[field]; ok {
+			field = alias
+		}
+		if todoCompare[field] == nil {
+			return nil, fmt.Errorf("unknown sort key %q", field)
+		}
+		key.name = field
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+func mustParseSort(spec string) []sortKey {
+	keys, err := parseSort(spec)
+	if err != nil {
+		panic(err)
+	}
+	return keys
+}
+
+// setSortOrder sets the order used by sortTodos from the -sort flag. An
+// empty spec selects the default order.
+func setSortOrder(spec string) error {
+	if spec == "" {
+		spec = defaultSort
+	}
+	keys, err := parseSort(spec)
+	if err != nil {
+		return err
+	}
+	sortOrder = keys
+	return nil
+}
+
+// lessTodo orders two todos by keys.
+func lessTodo(keys []sortKey, a, b *Todo) bool {
+	for _, key := range keys {
+		unsetA, unsetB := isUnset(key.name, a), isUnset(key.name, b)
+		if unsetA != unsetB {
+			return unsetB
+		}
+		if unsetA {
+			continue
+		}
+		c := todoCompare[key.name](a, b)
+		if key.desc {
+			c = -c
+		}
+		if c != 0 {
+			return c < 0
+		}
+	}
+	return false
+}
+
+func sortTodos(todoList *TodoList) {
+	sort.SliceStable(todoList.Todos, func(i, j int) bool {
+		return lessTodo(sortOrder, todoList.Todos[i], todoList.Todos[j])
+	})
+}
+
+func firstCategory(todo *Todo) string {
+	if len(todo.Categories) == 0 {
+		return ""
+	}
+	return todo.Categories[0]
+}
+
+// todoGroup is a heading in the main list and the todos below it.
+type todoGroup struct {
+	Name   string
+	Todos  []*Todo
+	Depths []int
+}
+
+// groupTitle returns the group a todo goes in for -group, and its rank
+// among the groups.
+var groupTitle = map[string]func(todo *Todo, now time.Time) (string, int){
+	"category": func(todo *Todo, now time.Time) (string, int) {
+		if cat := firstCategory(todo); cat != "" {
+			return "@" + cat, 0
+		}
+		return "No category", 1
+	},
+	"list": func(todo *Todo, now time.Time) (string, int) {
+		if todo.Collection == nil {
+			return "No list", 1
+		}
+		return todo.Collection.Name, 0
+	},
+	"due": func(todo *Todo, now time.Time) (string, int) {
+		if todo.DueDate.IsZero() {
+			return "No due date", 4
+		}
+		switch days := daysUntil(todo.DueDate, now); {
+		case days < 0:
+			return "Overdue", 0
+		case days == 0:
+			return "Today", 1
+		case days < 7:
+			return "This week", 2
+		}
+		return "Later", 3
+	},
+}
+
+// groupTodos splits todos in tree order into -group groups. Subtasks stay
+// in their parent's group. Groups are ordered by rank, then by their first
+// todo.
+func groupTodos(by string, ordered []*Todo, depths []int, now time.Time) []todoGroup {
+	title := groupTitle[by]
+	var groups []todoGroup
+	ranks := make(map[string]int)
+	index := make(map[string]int)
+	name := ""
+	for n, todo := range ordered {
+		if depths[n] == 0 {
+			var rank int
+			name, rank = title(todo, now)
+			if _, ok := index[name]; !ok {
+				index[name] = len(groups)
+				ranks[name] = rank
+				groups = append(groups, todoGroup{Name: name})
+			}
+		}
+		g := &groups[index[name]]
+		g.Todos = append(g.Todos, todo)
+		g.Depths = append(g.Depths, depths[n])
+	}
+	sort.SliceStable(groups, func(i, j int) bool {
+		ri, rj := ranks[groups[i].Name], ranks[groups[j].Name]
+		if ri != rj {
+			return ri < rj
+		}
+		if by == "category" {
+			return strings.ToLower(groups[i].Name) < strings.ToLower(groups[j].Name)
+		}
+		return false
+	})
+	return groups
+}
+
+// groupPrefix starts the heading lines of groups, which can't be selected.
+const groupPrefix = "── "
+
+func groupHeader(name string) string {
+	return groupPrefix + name + " ──"
+}
+
+func isGroupHeader(line string) bool {
+	return strings.HasPrefix(line, groupPrefix)
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func summaries(todos []*Todo) []string {
+	var s []string
+	for _, todo := range todos {
+		s = append(s, todo.Summary)
+	}
+	return s
+}
+
+func TestParseSort(t *testing.T) {
+	keys, err := parseSort("pri, -last-modified,alpha,+cat")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []sortKey{{"priority", false}, {"modified", true}, {"summary", false}, {"category", false}}
+	if !reflect.DeepEqual(keys, want) {
+		t.Errorf("Got %+v, want %+v", keys, want)
+	}
+	if _, err := parseSort("due,size"); err == nil {
+		t.Error("Expected an error for an unknown key")
+	}
+}
+
+func TestSortTodos(t *testing.T) {
+	day := func(d int) time.Time { return time.Date(2030, 1, d, 0, 0, 0, 0, time.Local) }
+	todos := []*Todo{
+		{Summary: "b", Priority: 0, DueDate: day(2)},
+		{Summary: "a", Priority: 3},
+		{Summary: "c", Priority: 1, DueDate: day(5)},
+		{Summary: "d", Priority: 3, DueDate: day(1)},
+	}
+	tests := []struct {
+		spec string
+		want string
+	}{
+		{"due,priority,-created", "d,b,c,a"},
+		{"priority,due", "c,d,a,b"},
+		{"-due", "c,b,d,a"}, // Unset values stay last
+		{"-priority,summary", "a,d,c,b"},
+		{"summary", "a,b,c,d"},
+	}
+	old := sortOrder
+	defer func() { sortOrder = old }()
+	for _, tt := range tests {
+		if err := setSortOrder(tt.spec); err != nil {
+			t.Fatal(err)
+		}
+		todoList := &TodoList{Todos: append([]*Todo(nil), todos...)}
+		sortTodos(todoList)
+		if got := strings.Join(summaries(todoList.Todos), ","); got != tt.want {
+			t.Errorf("%s: got %s, want %s", tt.spec, got, tt.want)
+		}
+	}
+}
+
+func TestGroupTodos(t *testing.T) {
+	now := time.Date(2030, 3, 10, 12, 0, 0, 0, time.Local)
+	at := func(days int) time.Time { return now.AddDate(0, 0, days) }
+	parent := &Todo{UID: "p", Summary: "Later one", DueDate: at(30)}
+	todos := []*Todo{
+		{UID: "a", Summary: "Late", DueDate: at(-1)},
+		parent,
+		{UID: "c", Summary: "Sub of later", ParentUID: "p", DueDate: at(0)},
+		{UID: "d", Summary: "Someday"},
+		{UID: "e", Summary: "Soon", DueDate: at(3)},
+		{UID: "f", Summary: "Now", DueDate: at(0)},
+	}
+	ordered, depths := treeOrder(todos)
+	var got []string
+	for _, g := range groupTodos("due", ordered, depths, now) {
+		got = append(got, g.Name+": "+strings.Join(summaries(g.Todos), ","))
+	}
+	want := []string{
+		"Overdue: Late",
+		"Today: Now",
+		"This week: Soon",
+		"Later: Later one,Sub of later",
+		"No due date: Someday",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Got %q, want %q", got, want)
+	}
+}
+
+func TestCreateMenuGroups(t *testing.T) {
+	defer func(old string) { *groupPtr = old }(*groupPtr)
+	*groupPtr = "category"
+	todoList := &TodoList{Todos: []*Todo{
+		{UID: "a", Summary: "Plain", Status: "NEEDS-ACTION"},
+		{UID: "b", Summary: "Weed", Categories: []string{"garden"}, Status: "NEEDS-ACTION"},
+		{UID: "c", Summary: "Invoice", Categories: []string{"Work", "garden"}, Status: "NEEDS-ACTION"},
+	}}
+	list := createMenu(todoList, openItems)
+	var headers []string
+	for i, line := range list.lines {
+		if isGroupHeader(line) {
+			headers = append(headers, line)
+			if list.todos[i] != nil || list.lookup(line) != nil {
+				t.Errorf("Expected header %q not to stand for a todo", line)
+			}
+		}
+	}
+	want := []string{"── @garden ──", "── @Work ──", "── No category ──"}
+	if !reflect.DeepEqual(headers, want) {
+		t.Errorf("Got headers %q, want %q", headers, want)
+	}
+	if len(list.todoLines()) != 3 {
+		t.Errorf("Expected every todo once, got %q", list.todoLines())
+	}
+
+	// The terminal UI skips headings
+	l := newTUIList(strings.Join(list.lines[4:], "\n"), "", nil, "")
+	if l.selected() != list.lines[5] {
+		t.Errorf("Expected the cursor on the first todo, got %q", l.selected())
+	}
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	ics "github.com/arran4/golang-ical"
+)
+
+// A .ics file may hold more than one component: several VTODOs from an
+// export, or VEVENTs and VJOURNALs next to a VTODO. A todo's FileName is the
+// file it lives in, which it may share with others, so deleting or moving
+// a todo only takes its own components out of the file.
+
+// componentUID returns the UID of a component, "" for VTIMEZONEs and
+// components without one.
+func componentUID(c ics.Component) string {
+	name, base := componentBase(c)
+	if base == nil || name == "VTIMEZONE" {
+		return ""
+	}
+	if p := base.GetProperty(ics.ComponentPropertyUniqueId); p != nil {
+		return p.Value
+	}
+	return ""
+}
+
+// fileUIDs returns the UIDs in a calendar in the order they first appear.
+// Components without a UID other than VTIMEZONEs count as "".
+func fileUIDs(cal *ics.Calendar) []string {
+	var uids []string
+	seen := make(map[string]bool)
+	for _, c := range cal.Components {
+		if _, ok := c.(*ics.VTimezone); ok {
+			continue
+		}
+		uid := componentUID(c)
+		if !seen[uid] {
+			seen[uid] = true
+			uids = append(uids, uid)
+		}
+	}
+	return uids
+}
+
+// hasOtherComponents reports whether the calendar holds anything besides
+// the components of uid and time zones.
+func hasOtherComponents(cal *ics.Calendar, uid string) bool {
+	for _, u := range fileUIDs(cal) {
+		if u != uid {
+			return true
+		}
+	}
+	return false
+}
+
+// timezoneRefs adds the TZIDs used by a component and its subcomponents to
+// refs.
+func timezoneRefs(c ics.Component, refs map[string]bool) {
+	_, base := componentBase(c)
+	if base == nil {
+		return
+	}
+	for _, p := range base.Properties {
+		for _, tzid := range p.ICalParameters[string(ics.ParameterTzid)] {
+			refs[tzid] = true
+		}
+	}
+	for _, sub := range base.Components {
+		timezoneRefs(sub, refs)
+	}
+}
+
+// keepComponents returns a calendar with the properties of cal and only the
+// components keep accepts, plus the time zones they use.
+func keepComponents(cal *ics.Calendar, keep func(uid string) bool) *ics.Calendar {
+	kept := &ics.Calendar{CalendarProperties: append([]ics.CalendarProperty(nil), cal.CalendarProperties...)}
+	refs := make(map[string]bool)
+	for _, c := range cal.Components {
+		if _, ok := c.(*ics.VTimezone); !ok && keep(componentUID(c)) {
+			timezoneRefs(c, refs)
+		}
+	}
+	for _, c := range cal.Components {
+		if tz, ok := c.(*ics.VTimezone); ok {
+			if p := tz.GetProperty(ics.ComponentPropertyTzid); p != nil && refs[p.Value] {
+				kept.Components = append(kept.Components, c)
+			}
+		} else if keep(componentUID(c)) {
+			kept.Components = append(kept.Components, c)
+		}
+	}
+	return kept
+}
+
+// removeFromFile takes the components of uid out of a file, or removes the
+// file if there is nothing else in it.
+func removeFromFile(filePath, uid string) error {
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		return err
+	}
+	cal, err := ics.ParseCalendar(bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	if !hasOtherComponents(cal, uid) {
+		return os.Remove(filePath)
+	}
+	keepListEscapes(cal, data)
+	rest := keepComponents(cal, func(u string) bool { return u != uid })
+	return writeCalendarFile(filePath, rest, data, "")
+}
+
+// freeFileName returns a file name for uid that isn't taken in dir or by
+// one of taken.
+func freeFileName(dir, uid string, taken []string) string {
+	name := todoFileName(uid)
+	base := strings.TrimSuffix(name, ".ics")
+	for n := 2; ; n++ {
+		if _, err := os.Stat(filepath.Join(dir, name)); os.IsNotExist(err) && !slices.Contains(taken, name) {
+			return name
+		}
+		name = base + "-" + strconv.Itoa(n) + ".ics"
+	}
+}
+
+// splitFile splits a file holding more than one UID into one file per UID,
+// as vdir expects. The UID whose own file name is the file's name stays in
+// it. It returns the new file names, or none if there was nothing to split.
+// With dryRun nothing is written.
+func splitFile(filePath string, dryRun bool) ([]string, error) {
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		return nil, err
+	}
+	cal, err := ics.ParseCalendar(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	uids := fileUIDs(cal)
+	if len(uids) < 2 {
+		return nil, nil
+	}
+	for _, uid := range uids {
+		if uid == "" {
+			return nil, fmt.Errorf("components without a UID can't be split")
+		}
+	}
+	keepListEscapes(cal, data)
+
+	dir, self := filepath.Split(filePath)
+	var names []string
+	stays := ""
+	for _, uid := range uids {
+		if todoFileName(uid) == self && stays == "" {
+			stays = uid
+			continue
+		}
+		name := freeFileName(dir, uid, names)
+		names = append(names, name)
+		if dryRun {
+			continue
+		}
+		part := keepComponents(cal, func(u string) bool { return u == uid })
+		if err := writeCalendarFile(filepath.Join(dir, name), part, data, ""); err != nil {
+			return names, err
+		}
+	}
+	if dryRun {
+		return names, nil
+	}
+	// Only touch the original once everything else is written
+	if stays != "" {
+		part := keepComponents(cal, func(u string) bool { return u == stays })
+		return names, writeCalendarFile(filePath, part, data, "")
+	}
+	return names, os.Remove(filePath)
+}
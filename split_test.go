@@ -0,0 +1,147 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// sharedCalendar is an export holding two todos, an event and a time zone
+// used by one of the todos.
+const sharedCalendar = "BEGIN:VCALENDAR\r\nVERSION:2.0\r\nPRODID:-//Example//Export//EN\r\n" +
+	"BEGIN:VTIMEZONE\r\nTZID:Europe/Berlin\r\nBEGIN:STANDARD\r\nDTSTART:19701025T030000\r\n" +
+	"TZOFFSETFROM:+0200\r\nTZOFFSETTO:+0100\r\nEND:STANDARD\r\nEND:VTIMEZONE\r\n" +
+	"BEGIN:VTODO\r\nUID:t1\r\nDTSTAMP:20240101T000000Z\r\nSUMMARY:Water plants\r\n" +
+	"DUE;TZID=Europe/Berlin:20240105T090000\r\nX-CUSTOM:kept\\, as is\r\nEND:VTODO\r\n" +
+	"BEGIN:VTODO\r\nUID:t2\r\nDTSTAMP:20240101T000000Z\r\nSUMMARY:Pay bills\r\nEND:VTODO\r\n" +
+	"BEGIN:VEVENT\r\nUID:e1\r\nDTSTAMP:20240101T000000Z\r\nDTSTART:20240110T100000Z\r\n" +
+	"SUMMARY:Dentist\r\nEND:VEVENT\r\n" +
+	"END:VCALENDAR\r\n"
+
+func writeShared(t *testing.T, dir string) string {
+	t.Helper()
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "export.ics")
+	if err := os.WriteFile(path, []byte(sharedCalendar), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestDeleteFromSharedFile(t *testing.T) {
+	dir := t.TempDir()
+	path := writeShared(t, dir)
+	todoList := loadListTestDir(t, dir)
+	if len(todoList.Todos) != 2 {
+		t.Fatalf("Expected two todos, got %d", len(todoList.Todos))
+	}
+
+	if !deleteTodo(findTodo(todoList, "t1"), todoList) {
+		t.Fatal("Expected the todo to be deleted")
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Expected the file to stay: %v", err)
+	}
+	for _, gone := range []string{"UID:t1", "Water plants", "VTIMEZONE"} {
+		if strings.Contains(string(data), gone) {
+			t.Errorf("Expected %q to be removed:\n%s", gone, data)
+		}
+	}
+	if !strings.Contains(string(data), "UID:t2") || !strings.Contains(string(data), "SUMMARY:Dentist\r\n") {
+		t.Errorf("Expected the other components to stay:\n%s", data)
+	}
+
+	// The event still lives in the file after the last todo goes
+	deleteTodo(findTodo(todoList, "t2"), todoList)
+	data, err = os.ReadFile(path)
+	if err != nil || !strings.Contains(string(data), "UID:e1") || strings.Contains(string(data), "VTODO") {
+		t.Errorf("Expected only the event left, got %v:\n%s", err, data)
+	}
+	if len(mustLoad(t, dir).Todos) != 0 {
+		t.Error("Expected no todos left")
+	}
+}
+
+func TestMoveFromSharedFile(t *testing.T) {
+	root := t.TempDir()
+	writeShared(t, filepath.Join(root, "work"))
+	writeList(t, filepath.Join(root, "home"), "", "h1", "Mow lawn")
+	// A file of the same name in the target list must not be overwritten
+	other := fmt.Sprintf(listTestTodo, "h2", "Rake leaves")
+	os.WriteFile(filepath.Join(root, "home", "export.ics"), []byte(other), 0644)
+	todoList := loadListTestDir(t, root)
+
+	todo := findTodo(todoList, "t1")
+	useMenu(t, "Move to list: work", "home", "Save item")
+	editItem(todo, todoList)
+	if err := saveTodos(todoList, root); err != nil {
+		t.Fatal(err)
+	}
+	if todo.FileName != "t1.ics" {
+		t.Errorf("Expected the moved todo in its own file, got %s", todo.FileName)
+	}
+	moved, err := os.ReadFile(filepath.Join(root, "home", "t1.ics"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(moved), "UID:t2") || !strings.Contains(string(moved), "TZID:Europe/Berlin") ||
+		!strings.Contains(string(moved), "X-CUSTOM:kept\\, as is\r\n") {
+		t.Errorf("Expected only the moved todo and its time zone:\n%s", moved)
+	}
+	old, _ := os.ReadFile(filepath.Join(root, "work", "export.ics"))
+	if strings.Contains(string(old), "UID:t1") || !strings.Contains(string(old), "UID:t2") {
+		t.Errorf("Expected the todo taken out of the old file:\n%s", old)
+	}
+	if same, _ := os.ReadFile(filepath.Join(root, "home", "export.ics")); string(same) != other {
+		t.Error("Expected the file of the same name in the other list to be untouched")
+	}
+}
+
+func TestCommandSplit(t *testing.T) {
+	dir := t.TempDir()
+	path := writeShared(t, dir)
+	// A file with one todo isn't touched
+	single := "BEGIN:VCALENDAR\r\nVERSION:2.0\r\nPRODID:x\r\nBEGIN:VTODO\r\nUID:s\r\nEND:VTODO\r\nEND:VCALENDAR\r\n"
+	os.WriteFile(filepath.Join(dir, "s.ics"), []byte(single), 0644)
+
+	out, errOut, code := runTestCommand(t, dir, "split", "-n")
+	if code != exitOK || !strings.Contains(out, "Would split "+path+" into t1.ics, t2.ics, e1.ics") {
+		t.Fatalf("Unexpected dry run output %q %q", out, errOut)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "t1.ics")); !os.IsNotExist(err) {
+		t.Error("Expected a dry run to write nothing")
+	}
+
+	if _, errOut, code := runTestCommand(t, dir, "split"); code != exitOK {
+		t.Fatalf("split exited %d: %s", code, errOut)
+	}
+	files, _ := filepath.Glob(filepath.Join(dir, "*.ics"))
+	var names []string
+	for _, f := range files {
+		names = append(names, filepath.Base(f))
+	}
+	sort.Strings(names)
+	if strings.Join(names, " ") != "e1.ics s.ics t1.ics t2.ics" {
+		t.Errorf("Expected one file per UID, got %v", names)
+	}
+	event, _ := os.ReadFile(filepath.Join(dir, "e1.ics"))
+	want := "BEGIN:VCALENDAR\r\nVERSION:2.0\r\nPRODID:-//Example//Export//EN\r\n" +
+		"BEGIN:VEVENT\r\nUID:e1\r\nDTSTAMP:20240101T000000Z\r\nDTSTART:20240110T100000Z\r\n" +
+		"SUMMARY:Dentist\r\nEND:VEVENT\r\nEND:VCALENDAR\r\n"
+	if string(event) != want {
+		t.Errorf("Expected the event copied unchanged, got:\n%s", event)
+	}
+	if data, _ := os.ReadFile(filepath.Join(dir, "s.ics")); string(data) != single {
+		t.Error("Expected the single todo file to be untouched")
+	}
+	todoList := mustLoad(t, dir)
+	if len(todoList.Todos) != 3 || findTodo(todoList, "t1").FileName != "t1.ics" {
+		t.Errorf("Expected the todos in their own files, got %d todos", len(todoList.Todos))
+	}
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// todoStatuses are the VTODO statuses from RFC 5545.
+var todoStatuses = []string{"NEEDS-ACTION", "IN-PROCESS", "COMPLETED", "CANCELLED"}
+
+// listKind selects which todos a list shows.
+type listKind int
+
+const (
+	openItems      listKind = iota // Needs action or in process
+	completedItems                 // Completed
+	cancelledItems                 // Cancelled
+)
+
+// kindOf returns the list a todo is shown in.
+func kindOf(todo *Todo) listKind {
+	switch todo.Status {
+	case "COMPLETED":
+		return completedItems
+	case "CANCELLED":
+		return cancelledItems
+	}
+	return openItems
+}
+
+// isOpen reports whether the todo still needs doing, so it is neither
+// completed nor cancelled.
+func isOpen(todo *Todo) bool {
+	return kindOf(todo) == openItems
+}
+
+// setStatus changes the status of a todo. Completing it sets the COMPLETED
+// time and 100% progress; moving it out of COMPLETED clears them again.
+func setStatus(todo *Todo, status string, now time.Time) {
+	if status == todo.Status {
+		return
+	}
+	if todo.Status == "COMPLETED" {
+		todo.Completed = time.Time{}
+		if todo.Percent == 100 {
+			todo.Percent = 0
+		}
+	}
+	if status == "COMPLETED" {
+		todo.Completed = now
+		todo.Percent = 100
+	}
+	todo.Status = status
+	todo.LastMod = now
+	todo.Modified = true
+}
+
+// editStatus offers the statuses to pick from.
+func editStatus(todo *Todo) {
+	out, err := menu.Show(strings.Join(todoStatuses, "\n"), "Status:")
+	if err != nil {
+		return
+	}
+	for _, status := range todoStatuses {
+		if strings.EqualFold(out, status) {
+			setStatus(todo, status, time.Now())
+			return
+		}
+	}
+}
+
+// editPercent asks for the PERCENT-COMPLETE of a todo. Starting work on a
+// todo that needs action makes it in process.
+func editPercent(todo *Todo) {
+	p, err := menu.Prompt("Progress (0-100%):", strconv.Itoa(todo.Percent))
+	if err != nil {
+		return
+	}
+	n, err := strconv.Atoi(strings.TrimSuffix(strings.TrimSpace(p), "%"))
+	if err != nil || n < 0 || n > 100 {
+		menu.Show("", "Progress must be a number between 0 and 100")
+		return
+	}
+	todo.Percent = n
+	if n > 0 && todo.Status == "NEEDS-ACTION" {
+		todo.Status = "IN-PROCESS"
+	}
+	todo.Modified = true
+}
+
+// formatPercent renders progress as [40%], or "" if there is none.
+func formatPercent(todo *Todo) string {
+	if todo.Percent <= 0 {
+		return ""
+	}
+	return fmt.Sprintf("[%d%%]", todo.Percent)
+}
+
+func hasCancelled(todoList *TodoList) bool {
+	for _, todo := range todoList.Todos {
+		if todo.Status == "CANCELLED" {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSetStatus(t *testing.T) {
+	now := time.Date(2030, 5, 1, 9, 0, 0, 0, time.UTC)
+	todo := &Todo{Status: "IN-PROCESS", Percent: 40}
+	setStatus(todo, "COMPLETED", now)
+	if !todo.Completed.Equal(now) || todo.Percent != 100 || !todo.Modified {
+		t.Errorf("Expected completion time and 100%%, got %+v", todo)
+	}
+	setStatus(todo, "NEEDS-ACTION", now)
+	if !todo.Completed.IsZero() || todo.Percent != 0 {
+		t.Errorf("Expected restoring to clear completion, got %+v", todo)
+	}
+	setStatus(todo, "CANCELLED", now)
+	if isOpen(todo) || kindOf(todo) != cancelledItems || !todo.Completed.IsZero() {
+		t.Errorf("Expected a cancelled todo, got %+v", todo)
+	}
+}
+
+func TestEditStatusAndProgress(t *testing.T) {
+	todo := &Todo{UID: "a", Summary: "Paint fence", Status: "NEEDS-ACTION"}
+	todoList := &TodoList{Todos: []*Todo{todo}}
+	s := useMenu(t,
+		"Progress: 0%", "140", "",
+		"Progress: 0%", "40%",
+		"Save item",
+	)
+	editItem(todo, todoList)
+	if todo.Percent != 40 || todo.Status != "IN-PROCESS" {
+		t.Errorf("Expected 40%% in process, got %d%% %s", todo.Percent, todo.Status)
+	}
+	if !slicesContainsPrefix(s.prompts, "Progress must be") {
+		t.Errorf("Expected a progress error, prompts were %q", s.prompts)
+	}
+	if line := formatTodoLine(todoList, todo, 0); !strings.Contains(line, "Paint fence [40%]") {
+		t.Errorf("Expected progress in the list line, got %q", line)
+	}
+
+	useMenu(t, "Status: IN-PROCESS", "CANCELLED", "Save item")
+	editItem(todo, todoList)
+	if todo.Status != "CANCELLED" {
+		t.Errorf("Expected the todo to be cancelled, got %s", todo.Status)
+	}
+}
+
+func TestCancelledItems(t *testing.T) {
+	open := &Todo{UID: "a", Summary: "Open", Status: "IN-PROCESS"}
+	cancelled := &Todo{UID: "b", Summary: "Dropped", Status: "CANCELLED"}
+	done := &Todo{UID: "c", Summary: "Done", Status: "COMPLETED"}
+	todoList := &TodoList{Todos: []*Todo{open, cancelled, done}}
+
+	list := createMenu(todoList, openItems)
+	if lines := list.todoLines(); len(lines) != 1 || list.lookup(lines[0]) != open {
+		t.Errorf("Expected only the open item in the main list, got %q", lines)
+	}
+	if !strings.Contains(list.String(), "View Cancelled Items") {
+		t.Errorf("Expected a cancelled items entry, got:\n%s", list)
+	}
+	for kind, want := range map[listKind]*Todo{completedItems: done, cancelledItems: cancelled} {
+		list := createMenu(todoList, kind)
+		if lines := list.todoLines(); len(lines) != 1 || list.lookup(lines[0]) != want {
+			t.Errorf("Expected %q on screen %d, got %q", want.Summary, kind, lines)
+		}
+	}
+
+	// Restoring a cancelled item from its screen reopens it
+	s := useMenu(t, createMenu(todoList, cancelledItems).todoLines()[0], "Restore item (uncomplete)", "Save item")
+	viewClosedItems(todoList, cancelledItems)
+	if cancelled.Status != "NEEDS-ACTION" || !slicesContainsPrefix(s.prompts, "Cancelled Items") {
+		t.Errorf("Expected the item to be restored, got %s, prompts %q", cancelled.Status, s.prompts)
+	}
+	if hasCancelled(todoList) {
+		t.Error("Expected no cancelled items left")
+	}
+}
+
+func TestSaveStatusProperties(t *testing.T) {
+	dir := t.TempDir()
+	todo := newTodo("")
+	todo.Summary = "Ship it"
+	todo.Percent = 60
+	completeTodo(todo)
+	if err := saveTodos(&TodoList{Todos: []*Todo{todo}}, dir); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(filepath.Join(dir, todo.FileName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"STATUS:COMPLETED", "PERCENT-COMPLETE:100", "COMPLETED:" + todo.Completed.UTC().Format("20060102T150405Z")} {
+		if !strings.Contains(string(data), want) {
+			t.Errorf("Expected %s in:\n%s", want, data)
+		}
+	}
+
+	loaded := mustLoad(t, dir).Todos[0]
+	if loaded.Percent != 100 || loaded.Completed.Unix() != todo.Completed.Unix() {
+		t.Errorf("Expected progress and completion time to load, got %d%% %v", loaded.Percent, loaded.Completed)
+	}
+	setStatus(loaded, "NEEDS-ACTION", time.Now())
+	if err := saveTodos(&TodoList{Todos: []*Todo{loaded}}, dir); err != nil {
+		t.Fatal(err)
+	}
+	data, _ = os.ReadFile(filepath.Join(dir, todo.FileName))
+	if strings.Contains(string(data), "\nCOMPLETED:") || strings.Contains(string(data), "PERCENT-COMPLETE") {
+		t.Errorf("Expected COMPLETED and PERCENT-COMPLETE to be removed:\n%s", data)
+	}
+}
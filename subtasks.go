@@ -0,0 +1,202 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	ics "github.com/arran4/golang-ical"
+)
+
+// relatedToParent returns the UID of the parent from the RELATED-TO
+// properties of a VTODO. A missing RELTYPE defaults to PARENT.
+func relatedToParent(vtodo *ics.VTodo) string {
+	for _, prop := range vtodo.Properties {
+		if prop.IANAToken != string(ics.ComponentPropertyRelatedTo) {
+			continue
+		}
+		if isParentRelation(prop) {
+			return prop.Value
+		}
+	}
+	return ""
+}
+
+func isParentRelation(prop ics.IANAProperty) bool {
+	reltype := prop.ICalParameters[string(ics.ParameterReltype)]
+	return len(reltype) == 0 || strings.EqualFold(reltype[0], "PARENT")
+}
+
+// setRelatedToParent replaces the parent RELATED-TO property of a VTODO,
+// leaving CHILD and SIBLING relations alone.
+func setRelatedToParent(vtodo *ics.VTodo, parentUID string) {
+	if relatedToParent(vtodo) == parentUID {
+		return
+	}
+	props := vtodo.Properties[:0]
+	for _, prop := range vtodo.Properties {
+		if prop.IANAToken == string(ics.ComponentPropertyRelatedTo) && isParentRelation(prop) {
+			continue
+		}
+		props = append(props, prop)
+	}
+	vtodo.Properties = props
+	if parentUID != "" {
+		vtodo.AddProperty(ics.ComponentPropertyRelatedTo, parentUID,
+			&ics.KeyValues{Key: string(ics.ParameterReltype), Value: []string{"PARENT"}})
+	}
+}
+
+func findTodo(todoList *TodoList, uid string) *Todo {
+	for _, todo := range todoList.Todos {
+		if todo.UID == uid {
+			return todo
+		}
+	}
+	return nil
+}
+
+// children returns the direct subtasks of a todo.
+func children(todoList *TodoList, parent *Todo) []*Todo {
+	var c []*Todo
+	for _, todo := range todoList.Todos {
+		if todo.ParentUID == parent.UID && todo != parent {
+			c = append(c, todo)
+		}
+	}
+	return c
+}
+
+// openDescendants returns all subtasks below a todo that are not completed.
+func openDescendants(todoList *TodoList, parent *Todo) []*Todo {
+	var open []*Todo
+	seen := map[*Todo]bool{parent: true}
+	queue := children(todoList, parent)
+	for len(queue) > 0 {
+		todo := queue[0]
+		queue = queue[1:]
+		if seen[todo] {
+			continue // Guard against RELATED-TO cycles
+		}
+		seen[todo] = true
+		if isOpen(todo) {
+			open = append(open, todo)
+		}
+		queue = append(queue, children(todoList, todo)...)
+	}
+	return open
+}
+
+// isDescendant reports whether todo is somewhere below ancestor.
+func isDescendant(todoList *TodoList, todo, ancestor *Todo) bool {
+	seen := map[string]bool{}
+	for t := todo; t != nil && t.ParentUID != "" && !seen[t.UID]; {
+		seen[t.UID] = true
+		if t.ParentUID == ancestor.UID {
+			return true
+		}
+		t = findTodo(todoList, t.ParentUID)
+	}
+	return false
+}
+
+// treeOrder arranges the given todos so that subtasks directly follow their
+// parent, keeping the existing order among siblings. Todos whose parent is
+// not in the list are shown at the top level. The depth of each todo is
+// returned alongside it.
+func treeOrder(todos []*Todo) ([]*Todo, []int) {
+	present := make(map[string]bool, len(todos))
+	for _, todo := range todos {
+		present[todo.UID] = true
+	}
+	kids := make(map[string][]*Todo)
+	var roots []*Todo
+	for _, todo := range todos {
+		if todo.ParentUID != "" && todo.ParentUID != todo.UID && present[todo.ParentUID] {
+			kids[todo.ParentUID] = append(kids[todo.ParentUID], todo)
+		} else {
+			roots = append(roots, todo)
+		}
+	}
+
+	var ordered []*Todo
+	var depths []int
+	visited := make(map[*Todo]bool)
+	var walk func(todo *Todo, depth int)
+	walk = func(todo *Todo, depth int) {
+		if visited[todo] {
+			return
+		}
+		visited[todo] = true
+		ordered = append(ordered, todo)
+		depths = append(depths, depth)
+		for _, child := range kids[todo.UID] {
+			walk(child, depth+1)
+		}
+	}
+	for _, todo := range roots {
+		walk(todo, 0)
+	}
+	// Anything left is part of a parent cycle; show it at the top level
+	for _, todo := range todos {
+		walk(todo, 0)
+	}
+	return ordered, depths
+}
+
+func addSubtask(parent *Todo, todoList *TodoList) {
+	addItem(todoList, parent.UID)
+}
+
+func setParent(todo *Todo, todoList *TodoList) {
+	list := &menuList{}
+	list.add("No parent", nil)
+	for _, t := range todoList.Todos {
+		if t == todo || !isOpen(t) || isDescendant(todoList, t, todo) {
+			continue
+		}
+		list.add(t.Summary, t)
+	}
+	out, e := menu.Show(list.String(), "Parent:")
+	if e != nil {
+		return
+	}
+	if out == "No parent" {
+		todo.ParentUID = ""
+	} else if parent := list.lookup(out); parent != nil {
+		todo.ParentUID = parent.UID
+	} else {
+		return
+	}
+	todo.Modified = true
+}
+
+func parentSummary(todo *Todo, todoList *TodoList) string {
+	if todo.ParentUID == "" {
+		return ""
+	}
+	if parent := findTodo(todoList, todo.ParentUID); parent != nil {
+		return parent.Summary
+	}
+	return todo.ParentUID
+}
+
+// confirmOpenSubtasks asks what to do with the open subtasks of a todo that
+// is being completed or deleted. It returns whether to include the subtasks
+// and false for ok if the user cancelled.
+func confirmOpenSubtasks(todo *Todo, todoList *TodoList, action string) (cascade bool, ok bool) {
+	open := openDescendants(todoList, todo)
+	if len(open) == 0 {
+		return false, true
+	}
+	choices := fmt.Sprintf("%s subtasks too\n%s only this item\nCancel", action, action)
+	out, e := menu.Show(choices, fmt.Sprintf("%d open subtask(s):", len(open)))
+	switch {
+	case e != nil || out == "Cancel":
+		return false, false
+	case strings.HasSuffix(out, "subtasks too"):
+		return true, true
+	case strings.HasSuffix(out, "only this item"):
+		return false, true
+	}
+	return false, false
+}
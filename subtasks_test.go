@@ -0,0 +1,184 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestTreeOrder(t *testing.T) {
+	parent := &Todo{UID: "p", Summary: "Parent"}
+	child := &Todo{UID: "c", Summary: "Child", ParentUID: "p"}
+	grandchild := &Todo{UID: "g", Summary: "Grandchild", ParentUID: "c"}
+	orphan := &Todo{UID: "o", Summary: "Orphan", ParentUID: "missing"}
+	loopA := &Todo{UID: "a", ParentUID: "b"}
+	loopB := &Todo{UID: "b", ParentUID: "a"}
+
+	ordered, depths := treeOrder([]*Todo{grandchild, orphan, child, parent, loopA, loopB})
+	expected := []*Todo{orphan, parent, child, grandchild, loopA, loopB}
+	expectedDepths := []int{0, 0, 1, 2, 0, 1}
+	if len(ordered) != len(expected) {
+		t.Fatalf("Expected %d todos, got %d", len(expected), len(ordered))
+	}
+	for i := range expected {
+		if ordered[i] != expected[i] || depths[i] != expectedDepths[i] {
+			t.Errorf("Position %d: expected %s at depth %d, got %s at depth %d",
+				i, expected[i].UID, expectedDepths[i], ordered[i].UID, depths[i])
+		}
+	}
+}
+
+func TestOpenDescendants(t *testing.T) {
+	parent := &Todo{UID: "p", Status: "NEEDS-ACTION"}
+	done := &Todo{UID: "d", ParentUID: "p", Status: "COMPLETED"}
+	open := &Todo{UID: "o", ParentUID: "d", Status: "NEEDS-ACTION"}
+	other := &Todo{UID: "x", Status: "NEEDS-ACTION"}
+	todoList := &TodoList{Todos: []*Todo{parent, done, open, other}}
+
+	descendants := openDescendants(todoList, parent)
+	if len(descendants) != 1 || descendants[0] != open {
+		t.Errorf("Expected only the open grandchild, got %v", descendants)
+	}
+	if !isDescendant(todoList, open, parent) {
+		t.Error("Expected grandchild to be a descendant of parent")
+	}
+	if isDescendant(todoList, parent, open) {
+		t.Error("Expected parent not to be a descendant of grandchild")
+	}
+}
+
+func TestCreateMenuIndentsSubtasks(t *testing.T) {
+	parent := &Todo{UID: "p", Summary: "Parent", Status: "NEEDS-ACTION"}
+	child := &Todo{UID: "c", Summary: "Child", ParentUID: "p", Status: "NEEDS-ACTION"}
+	todoList := &TodoList{Todos: []*Todo{child, parent}}
+
+	lines := strings.Split(createMenu(todoList, openItems).String(), "\n")
+	if len(lines) != 6 {
+		t.Fatalf("Expected 6 menu lines, got %v", lines)
+	}
+	if !strings.HasSuffix(lines[4], " Parent") || !strings.HasSuffix(lines[5], "↳ Child") {
+		t.Errorf("Expected child indented under parent, got %v", lines[4:])
+	}
+}
+
+func TestSaveRelatedTo(t *testing.T) {
+	tempDir := t.TempDir()
+	ics := "BEGIN:VCALENDAR\r\nVERSION:2.0\r\nBEGIN:VTODO\r\nUID:c\r\nSUMMARY:Child\r\n" +
+		"RELATED-TO;RELTYPE=SIBLING:s\r\nRELATED-TO:old\r\nEND:VTODO\r\nEND:VCALENDAR\r\n"
+	if err := os.WriteFile(filepath.Join(tempDir, "c.ics"), []byte(ics), 0644); err != nil {
+		t.Fatalf("Failed to write test file: %v", err)
+	}
+
+	todoList, err := loadTodos(tempDir)
+	if err != nil {
+		t.Fatalf("Failed to load todos: %v", err)
+	}
+	todo := todoList.Todos[0]
+	if todo.ParentUID != "old" {
+		t.Fatalf("Expected parent 'old', got %q", todo.ParentUID)
+	}
+	todo.ParentUID = "new"
+	todo.Modified = true
+	if err := saveTodos(todoList, tempDir); err != nil {
+		t.Fatalf("Failed to save todos: %v", err)
+	}
+
+	data, err := os.ReadFile(filepath.Join(tempDir, "c.ics"))
+	if err != nil {
+		t.Fatalf("Failed to read saved file: %v", err)
+	}
+	saved := string(data)
+	if !strings.Contains(saved, "RELATED-TO;RELTYPE=SIBLING:s") {
+		t.Error("Expected SIBLING relation to be preserved")
+	}
+	if !strings.Contains(saved, "RELATED-TO;RELTYPE=PARENT:new") || strings.Contains(saved, ":old") {
+		t.Errorf("Expected parent relation to be replaced, got:\n%s", saved)
+	}
+}
+
+func TestDeleteItemSubtasks(t *testing.T) {
+	tree := func() *TodoList {
+		return &TodoList{Todos: []*Todo{
+			{UID: "top", Status: "NEEDS-ACTION"},
+			{UID: "p", Summary: "Parent", ParentUID: "top", Status: "NEEDS-ACTION"},
+			{UID: "done", ParentUID: "p", Status: "COMPLETED"},
+			{UID: "doneOpen", ParentUID: "done", Status: "NEEDS-ACTION"},
+			{UID: "open", ParentUID: "p", Status: "NEEDS-ACTION"},
+			{UID: "openDone", ParentUID: "open", Status: "COMPLETED"},
+			{UID: "openOpen", ParentUID: "open", Status: "NEEDS-ACTION"},
+		}}
+	}
+	parents := func(todoList *TodoList) map[string]string {
+		m := make(map[string]string)
+		for _, todo := range todoList.Todos {
+			m[todo.UID] = todo.ParentUID
+		}
+		return m
+	}
+
+	// Open subtasks go at every level, completed ones move up to "top"
+	todoList := tree()
+	useMenu(t, "y", "Delete subtasks too")
+	if !deleteItem(findTodo(todoList, "p"), todoList) {
+		t.Fatal("Expected the item to be deleted")
+	}
+	want := map[string]string{"top": "", "done": "top", "openDone": "top"}
+	if got := parents(todoList); !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected %v, got %v", want, got)
+	}
+
+	// Only this item: the direct subtasks move up and the rest stay
+	todoList = tree()
+	useMenu(t, "y", "Delete only this item")
+	deleteItem(findTodo(todoList, "p"), todoList)
+	want = map[string]string{"top": "", "done": "top", "doneOpen": "done", "open": "top", "openDone": "open", "openOpen": "open"}
+	if got := parents(todoList); !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected %v, got %v", want, got)
+	}
+
+	// Nothing below an item is touched if its file can't be removed
+	todoList = tree()
+	p := findTodo(todoList, "p")
+	p.FileName, p.Collection = "missing.ics", &Collection{Dir: t.TempDir()}
+	useMenu(t, "y", "Delete subtasks too")
+	if deleteItem(p, todoList) {
+		t.Error("Expected the delete to fail")
+	}
+	for _, todo := range todoList.Todos {
+		if todo.Modified {
+			t.Errorf("Expected %s to be left alone", todo.UID)
+		}
+	}
+	if len(todoList.Todos) != 7 {
+		t.Errorf("Expected nothing deleted, got %d todos", len(todoList.Todos))
+	}
+}
+
+func TestCompleteSubtasksRevertedOnCancel(t *testing.T) {
+	todoList := &TodoList{Todos: []*Todo{
+		{UID: "p", Summary: "Parent", Status: "NEEDS-ACTION"},
+		{UID: "c", ParentUID: "p", Status: "NEEDS-ACTION"},
+		{UID: "g", ParentUID: "c", Status: "IN-PROCESS", Percent: 40},
+	}}
+	useMenu(t, "Complete item", "Complete subtasks too", menuEscape)
+	editItem(todoList.Todos[0], todoList)
+	for _, todo := range todoList.Todos {
+		if !isOpen(todo) || todo.Modified {
+			t.Errorf("Expected %s reverted, got %s", todo.UID, todo.Status)
+		}
+	}
+	if g := todoList.Todos[2]; g.Status != "IN-PROCESS" || g.Percent != 40 {
+		t.Errorf("Expected the subtask as it was, got %s %d%%", g.Status, g.Percent)
+	}
+
+	// Saving keeps them completed
+	useMenu(t, "Complete item", "Complete subtasks too", "Save item")
+	editItem(todoList.Todos[0], todoList)
+	for _, todo := range todoList.Todos {
+		if todo.Status != "COMPLETED" {
+			t.Errorf("Expected %s completed, got %s", todo.UID, todo.Status)
+		}
+	}
+}
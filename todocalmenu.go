@@ -5,9 +5,9 @@ import (
 	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"log"
 	"os"
-	"os/exec"
 	"path/filepath"
 	"sort"
 	"strconv"
@@ -20,8 +20,21 @@ import (
 var hideCreatedDatePtr = flag.Bool("hide-created-date", false, "Hide created date in the list view")
 var optsPtr = flag.String("opts", "", "Additional Rofi/Dmenu options")
 var thresholdPtr = flag.Bool("threshold", false, "Hide items before their threshold date")
-var todoPtr = flag.String("todo", "./todos", "Path to todo directory")
-var cmdPtr = flag.String("cmd", "dmenu", "Dmenu command to use (dmenu, rofi, wofi, etc)")
+var todoPtr = flag.String("todo", "./todos", "Path to todo directory, or comma separated directories of lists")
+var listFilterPtr = flag.String("list", "", "Only show todos in these comma separated lists")
+var cmdPtr = flag.String("cmd", "dmenu", "Dmenu command to use (dmenu, rofi, wofi, fzf, etc) or tui for the built-in terminal UI")
+var notifyPtr = flag.Bool("notify", false, "Run as a daemon sending notifications for todo alarms")
+var notifyCmdPtr = flag.String("notify-cmd", "notify-send", "Command used to send alarm notifications")
+var notifyIntervalPtr = flag.Duration("notify-interval", time.Minute, "How often to check for alarms in -notify mode")
+var configPtr = flag.String("config", "", "Config file (default $XDG_CONFIG_HOME/todocalmenu/config.toml)")
+var defaultPriorityPtr = flag.Int("default-priority", 0, "Priority for new items, 0 for none")
+var defaultCategoriesPtr = flag.String("default-categories", "", "Comma separated categories for new items")
+var formatPtr = flag.String("format", "", "Template for list lines, e.g. '{{pad 30 .Summary}} {{.RelDue}}'")
+var sortPtr = flag.String("sort", "", "Comma separated sort keys, - for descending: priority, due, start, created, modified, summary, category, list (default due,priority,-created)")
+var groupPtr = flag.String("group", "", "Group the main list by category, list or due")
+var filterPtr = flag.String("filter", "", "Only show todos matching a query, e.g. 'cat:work due<7d pri<=3'")
+var viewPtr = flag.String("view", "", "Start with a view from the config file")
+var keysPtr = flag.String("keys", "", "Shortcut keys for the main list as key=action pairs, e.g. Alt+c=complete,Alt+x=delete")
 
 type Todo struct {
 	UID         string
@@ -29,48 +42,132 @@ type Todo struct {
 	Description string
 	Categories  []string
 	Status      string
+	Percent     int       // PERCENT-COMPLETE, 0 if unset
+	Completed   time.Time // When the todo was completed
 	Created     time.Time
 	LastMod     time.Time
 	DueDate     time.Time
+	DueKind     DateKind // Form DUE was written in
+	DueTZID     string   // TZID of DUE if it is zoned
 	Priority    int
 	StartDate   time.Time
-	Modified    bool // New field to track changes in the current session
+	StartKind   DateKind    // Form DTSTART was written in
+	StartTZID   string      // TZID of DTSTART if it is zoned
+	RRule       string      // Raw RRULE value, empty if the todo doesn't recur
+	RDates      []time.Time // Additional occurrences from RDATE
+	ExDates     []time.Time // Excluded occurrences from EXDATE
+	ParentUID   string      // UID of the parent todo from RELATED-TO
+	Alarms      []Alarm     // VALARM reminders
+	FileName    string      // Name of the .ics file the todo was loaded from
+	Collection  *Collection // List the todo belongs to, nil if loaded on its own
+	MovedFrom   string      // Old file to remove once saved in another list
+	Modified    bool        // New field to track changes in the current session
 }
 
 type TodoList struct {
-	Todos []*Todo
+	Todos       []*Todo
+	Collections []*Collection
 }
 
 func main() {
 	flag.Parse()
+	if err := loadConfig(*configPtr, flag.CommandLine); err != nil {
+		log.Fatalf("Failed to load config: %v", err)
+	}
+	baseView = view{Filter: *filterPtr, Sort: *sortPtr, Format: *formatPtr, Group: *groupPtr}
+	if err := selectView(*viewPtr); err != nil {
+		log.Fatalf("Bad view settings: %v", err)
+	}
+
+	// Ensure the todo directories exist
+	for _, dir := range todoDirs(*todoPtr) {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			log.Fatalf("Failed to create todo directory: %v", err)
+		}
+	}
 
-	// Ensure the todo directory exists
-	if err := os.MkdirAll(*todoPtr, 0755); err != nil {
-		log.Fatalf("Failed to create todo directory: %v", err)
+	if *notifyPtr {
+		runNotifier(*todoPtr, *notifyCmdPtr, *notifyIntervalPtr)
+		return
 	}
 
-	todoList, err := loadTodos(*todoPtr)
+	if flag.NArg() > 0 {
+		os.Exit(runCommand(flag.Args(), *todoPtr, os.Stdout, os.Stderr))
+	}
+
+	collections, err := findCollections(*todoPtr)
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+	todoList, err := loadCollections(collections)
 	if err != nil {
 		log.Fatal(err.Error())
 	}
+	menu = newMenu(*cmdPtr, *optsPtr)
+	runMenu(todoList)
+	if err := saveTodos(todoList, collections[0].Dir); err != nil {
+		log.Fatal(err.Error())
+	}
+}
+
+// runMenu shows the main list until the user backs out. Menus that need it,
+// like the built-in TUI, are closed on the way out, even on a panic, so the
+// terminal is always restored.
+func runMenu(todoList *TodoList) {
+	if c, ok := menu.(io.Closer); ok {
+		defer c.Close()
+	}
+	keys := menuKeys(menu, *keysPtr)
 	for edit := true; edit; {
-		displayList, m := createMenu(todoList, false)
-		out, _ := display(displayList.String(), *todoPtr)
+		list := createMenu(todoList, openItems)
+		out, key, err := showKeys(list.String(), menuPrompt(), keys)
+		if err != nil && !errors.Is(err, errEscape) {
+			log.Print(err)
+		}
+		// Todo lines come first, so a -format that renders a todo like a
+		// header line still opens the todo
+		todo := list.lookup(out)
 		switch {
+		case key != "":
+			if todo != nil {
+				runAction(keys[key], todo, todoList)
+			}
+		case todo != nil:
+			editItem(todo, todoList)
 		case out == "Add Item":
-			addItem(todoList)
+			addItem(todoList, "")
 		case out == "View Completed Items":
-			viewCompletedItems(todoList)
-		case out != "":
-			t := todoList.Todos[m[out]]
-			editItem(t, todoList)
-		default:
+			viewClosedItems(todoList, completedItems)
+		case out == "View Cancelled Items":
+			viewClosedItems(todoList, cancelledItems)
+		case out == "Bulk Edit":
+			bulkEdit(todoList)
+		case out == "Filter…" || strings.HasPrefix(out, "Filter: "):
+			editFilter()
+		case strings.HasPrefix(out, "Lists: "):
+			filterLists(todoList)
+		case out == "View: "+allItemsView && currentView != "":
+			selectView("")
+		case strings.HasPrefix(out, "View: "):
+			if err := selectView(strings.TrimPrefix(out, "View: ")); err != nil {
+				log.Print(err)
+			}
+		case out == "":
 			edit = false
 		}
 	}
-	if err := saveTodos(todoList, *todoPtr); err != nil {
-		log.Fatal(err.Error())
+}
+
+// menuPrompt is the main list prompt, the view, the lists shown or the todo
+// directory.
+func menuPrompt() string {
+	if currentView != "" {
+		return currentView
+	}
+	if *listFilterPtr != "" {
+		return *listFilterPtr
 	}
+	return *todoPtr
 }
 
 func loadTodos(dirPath string) (*TodoList, error) {
@@ -93,8 +190,10 @@ func loadTodos(dirPath string) (*TodoList, error) {
 		}
 
 		for _, component := range cal.Components {
-			if todo, ok := component.(*ics.VTodo); ok {
-				todoList.Todos = append(todoList.Todos, convertVTodoToTodo(todo))
+			if vtodo, ok := component.(*ics.VTodo); ok {
+				todo := convertVTodoToTodo(vtodo)
+				todo.FileName = file.Name()
+				todoList.Todos = append(todoList.Todos, todo)
 			}
 		}
 	}
@@ -112,7 +211,12 @@ func loadICSFile(filePath string) (*ics.Calendar, error) {
 		return nil, err
 	}
 
-	return ics.ParseCalendar(bytes.NewReader(data))
+	cal, err := ics.ParseCalendar(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	keepListEscapes(cal, data)
+	return cal, nil
 }
 
 func convertVTodoToTodo(vtodo *ics.VTodo) *Todo {
@@ -131,6 +235,12 @@ func convertVTodoToTodo(vtodo *ics.VTodo) *Todo {
 	} else {
 		todo.Status = "NEEDS-ACTION" // Default status if not set
 	}
+	if percent := vtodo.GetProperty(ics.ComponentPropertyPercentComplete); percent != nil {
+		todo.Percent, _ = strconv.Atoi(percent.Value)
+	}
+	if completed := vtodo.GetProperty(ics.ComponentPropertyCompleted); completed != nil {
+		todo.Completed = parseDateTime(completed.Value)
+	}
 	if created := vtodo.GetProperty(ics.ComponentPropertyCreated); created != nil {
 		todo.Created = parseDateTime(created.Value)
 	}
@@ -138,16 +248,27 @@ func convertVTodoToTodo(vtodo *ics.VTodo) *Todo {
 		todo.LastMod = parseDateTime(lastMod.Value)
 	}
 	if due := vtodo.GetProperty(ics.ComponentPropertyDue); due != nil {
-		todo.DueDate = parseDateTime(due.Value)
+		todo.DueDate, todo.DueKind, todo.DueTZID = parseDateProperty(due.Value, due.ICalParameters)
 	}
 	if priority := vtodo.GetProperty(ics.ComponentPropertyPriority); priority != nil {
 		todo.Priority, _ = strconv.Atoi(priority.Value)
 	}
-	if categories := vtodo.GetProperty(ics.ComponentPropertyCategories); categories != nil {
-		todo.Categories = strings.Split(categories.Value, ",")
-	}
+	todo.Categories = todoCategories(vtodo)
 	if start := vtodo.GetProperty(ics.ComponentPropertyDtStart); start != nil {
-		todo.StartDate = parseDateTime(start.Value)
+		todo.StartDate, todo.StartKind, todo.StartTZID = parseDateProperty(start.Value, start.ICalParameters)
+	}
+	if rrule := vtodo.GetProperty(ics.ComponentPropertyRrule); rrule != nil {
+		todo.RRule = rrule.Value
+	}
+	todo.ParentUID = relatedToParent(vtodo)
+	todo.Alarms = convertVAlarms(vtodo)
+	for _, prop := range vtodo.Properties {
+		switch ics.ComponentProperty(prop.IANAToken) {
+		case ics.ComponentPropertyRdate:
+			todo.RDates = append(todo.RDates, parseDateTimeList(prop.Value, prop.ICalParameters)...)
+		case ics.ComponentPropertyExdate:
+			todo.ExDates = append(todo.ExDates, parseDateTimeList(prop.Value, prop.ICalParameters)...)
+		}
 	}
 
 	return todo
@@ -163,23 +284,6 @@ func parseDateTime(value string) time.Time {
 		if err == nil {
 			return t.Local() // Convert UTC to local time
 		}
-	} else if strings.Contains(value, "TZID=") {
-		// TZID format
-		parts := strings.SplitN(value, ":", 2)
-		if len(parts) == 2 {
-			tzParts := strings.SplitN(parts[0], "=", 2)
-			if len(tzParts) == 2 {
-				tzName := tzParts[1]
-				dateTimeStr := parts[1]
-				loc, err := time.LoadLocation(tzName)
-				if err == nil {
-					t, err = time.ParseInLocation("20060102T150405", dateTimeStr, loc)
-					if err == nil {
-						return t.Local() // Convert to local time
-					}
-				}
-			}
-		}
 	} else {
 		// Handle other formats
 		switch {
@@ -198,97 +302,265 @@ func parseDateTime(value string) time.Time {
 	return t
 }
 
+// parseDateTimeList parses a comma separated list of date-times as used by
+// RDATE and EXDATE.
+func parseDateTimeList(value string, params map[string][]string) []time.Time {
+	var times []time.Time
+	for _, v := range strings.Split(value, ",") {
+		if t, _, _ := parseDateProperty(v, params); !t.IsZero() {
+			times = append(times, t)
+		}
+	}
+	return times
+}
+
+// saveTodos writes the modified todos to their list's directory, or to
+// dirPath if they don't belong to a list.
 func saveTodos(todoList *TodoList, dirPath string) error {
+	var errs []error
 	for _, todo := range todoList.Todos {
 		if !todo.Modified {
 			continue // Skip unmodified todos
 		}
+		dir := dirPath
+		if todo.Collection != nil {
+			dir = todo.Collection.Dir
+		}
+		if err := saveTodo(todo, dir); err != nil {
+			// Keep going so one bad file doesn't lose the rest of the edits
+			errs = append(errs, err)
+			continue
+		}
+		todo.Modified = false // Reset the modified flag after saving
+	}
 
-		fileName := todo.UID + ".ics"
-		filePath := filepath.Join(dirPath, fileName)
+	return errors.Join(errs...)
+}
 
-		// Read existing calendar if file exists
-		var cal *ics.Calendar
-		var err error
-		if _, err := os.Stat(filePath); err == nil {
-			cal, err = loadICSFile(filePath)
-			if err != nil {
-				return fmt.Errorf("error loading existing file %s: %v", filePath, err)
-			}
-		} else {
-			cal = ics.NewCalendar()
-		}
+func saveTodo(todo *Todo, dirPath string) error {
+	if todo.FileName == "" {
+		todo.FileName = todoFileName(todo.UID)
+	}
+	filePath := filepath.Join(dirPath, todo.FileName)
 
-		// Find existing VTODO or create new one
-		var vtodo *ics.VTodo
-		for _, component := range cal.Components {
-			if t, ok := component.(*ics.VTodo); ok && t.Id() == todo.UID {
-				vtodo = t
-				break
-			}
+	// Read existing calendar if file exists, from the old list if the todo
+	// was moved
+	srcPath := filePath
+	if todo.MovedFrom != "" {
+		srcPath = todo.MovedFrom
+	}
+	var cal *ics.Calendar
+	original, err := os.ReadFile(srcPath)
+	if err == nil {
+		cal, err = ics.ParseCalendar(bytes.NewReader(original))
+		if err != nil {
+			return fmt.Errorf("error loading existing file %s: %v", srcPath, err)
 		}
-		if vtodo == nil {
-			vtodo = cal.AddTodo(todo.UID)
+		keepListEscapes(cal, original)
+		ensureCalendarProperties(cal)
+	} else {
+		cal = ics.NewCalendar()
+	}
+	moving := todo.MovedFrom != "" && todo.MovedFrom != filePath
+	if moving {
+		// Only this todo moves; anything else in its old file stays there,
+		// and a file of the same name in the new list is left alone
+		shared := hasOtherComponents(cal, todo.UID)
+		cal = keepComponents(cal, func(uid string) bool { return uid == todo.UID })
+		if _, err := os.Stat(filePath); shared || err == nil {
+			todo.FileName = freeFileName(dirPath, todo.UID, nil)
+			filePath = filepath.Join(dirPath, todo.FileName)
 		}
+	}
 
-		// Update only the fields we manage
-		setPropertyIfNotEmpty(vtodo, ics.ComponentPropertySummary, todo.Summary)
-		setPropertyIfNotEmpty(vtodo, ics.ComponentPropertyDescription, todo.Description)
+	// Find existing VTODO or create new one
+	var vtodo *ics.VTodo
+	for _, component := range cal.Components {
+		if t, ok := component.(*ics.VTodo); ok && t.Id() == todo.UID {
+			vtodo = t
+			break
+		}
+	}
+	now := time.Now()
+	if vtodo == nil {
+		vtodo = cal.AddTodo(todo.UID)
+		vtodo.SetSequence(0)
+	} else {
+		// Servers use SEQUENCE to tell which copy of a changed todo is newer
+		seq := 0
+		if p := vtodo.GetProperty(ics.ComponentPropertySequence); p != nil {
+			seq, _ = strconv.Atoi(p.Value)
+		}
+		vtodo.SetSequence(seq + 1)
+	}
+	// Without a METHOD, DTSTAMP is when the todo was last written
+	vtodo.SetDtStampTime(now)
+
+	// Update only the fields we manage
+	setPropertyIfNotEmpty(vtodo, ics.ComponentPropertySummary, todo.Summary)
+	setPropertyIfNotEmpty(vtodo, ics.ComponentPropertyDescription, todo.Description)
+	// NEEDS-ACTION is what a missing STATUS means, so don't add it
+	if todo.Status != "NEEDS-ACTION" || vtodo.GetProperty(ics.ComponentPropertyStatus) != nil {
 		setPropertyIfNotEmpty(vtodo, ics.ComponentPropertyStatus, todo.Status)
-		setPropertyIfNotEmpty(vtodo, ics.ComponentPropertyLastModified, todo.LastMod.UTC().Format("20060102T150405Z"))
+	}
+	setPropertyIfNotEmpty(vtodo, ics.ComponentPropertyLastModified, todo.LastMod.UTC().Format("20060102T150405Z"))
 
-		// Convert DTSTART to UTC and save
-		if !todo.StartDate.IsZero() {
-			setPropertyIfNotEmpty(vtodo, ics.ComponentPropertyDtStart, todo.StartDate.UTC().Format("20060102T150405Z"))
-		} else {
-			removeProperty(vtodo, ics.ComponentPropertyDtStart)
-		}
+	// Write DTSTART and DUE back in the form they were read
+	setDateProperty(cal, vtodo, ics.ComponentPropertyDtStart, todo.StartDate, todo.StartKind, todo.StartTZID)
+	setDateProperty(cal, vtodo, ics.ComponentPropertyDue, todo.DueDate, todo.DueKind, todo.DueTZID)
 
-		// Convert DUE to UTC and save
-		if !todo.DueDate.IsZero() {
-			setPropertyIfNotEmpty(vtodo, ics.ComponentPropertyDue, todo.DueDate.UTC().Format("20060102T150405Z"))
-		} else {
-			removeProperty(vtodo, ics.ComponentPropertyDue)
-		}
+	setPropertyIfNotEmpty(vtodo, ics.ComponentPropertyRrule, todo.RRule)
+	setRelatedToParent(vtodo, todo.ParentUID)
+	setVAlarms(vtodo, todo)
 
-		if todo.Priority > 0 {
-			setPropertyIfNotEmpty(vtodo, ics.ComponentPropertyPriority, strconv.Itoa(todo.Priority))
-		} else {
-			removeProperty(vtodo, ics.ComponentPropertyPriority)
-		}
+	if todo.Priority > 0 {
+		setPropertyIfNotEmpty(vtodo, ics.ComponentPropertyPriority, strconv.Itoa(todo.Priority))
+	} else {
+		removeProperty(vtodo, ics.ComponentPropertyPriority)
+	}
 
-		if len(todo.Categories) > 0 {
-			setPropertyIfNotEmpty(vtodo, ics.ComponentPropertyCategories, strings.Join(todo.Categories, ","))
-		} else {
-			removeProperty(vtodo, ics.ComponentPropertyCategories)
-		}
+	if todo.Percent > 0 {
+		setPropertyIfNotEmpty(vtodo, ics.ComponentPropertyPercentComplete, strconv.Itoa(todo.Percent))
+	} else {
+		removeProperty(vtodo, ics.ComponentPropertyPercentComplete)
+	}
+	if todo.Status == "COMPLETED" && todo.Completed.IsZero() {
+		todo.Completed = now
+	}
+	if todo.Status == "COMPLETED" {
+		setPropertyIfNotEmpty(vtodo, ics.ComponentPropertyCompleted, todo.Completed.UTC().Format("20060102T150405Z"))
+	} else {
+		removeProperty(vtodo, ics.ComponentPropertyCompleted)
+	}
+
+	setCategories(vtodo, todo.Categories)
 
-		// Preserve CREATED if it exists, otherwise set it
-		if created := vtodo.GetProperty(ics.ComponentPropertyCreated); created == nil {
-			setPropertyIfNotEmpty(vtodo, ics.ComponentPropertyCreated, todo.Created.UTC().Format("20060102T150405Z"))
+	// Preserve CREATED if it exists, otherwise set it
+	if created := vtodo.GetProperty(ics.ComponentPropertyCreated); created == nil {
+		setPropertyIfNotEmpty(vtodo, ics.ComponentPropertyCreated, todo.Created.UTC().Format("20060102T150405Z"))
+	}
+
+	if err := writeCalendarFile(filePath, cal, original, todo.UID); err != nil {
+		return fmt.Errorf("error saving todo %s: %v", todo.UID, err)
+	}
+	if moving {
+		if err := removeFromFile(todo.MovedFrom, todo.UID); err != nil {
+			return fmt.Errorf("error removing moved todo %s: %v", todo.MovedFrom, err)
 		}
+	}
+	todo.MovedFrom = ""
+	return nil
+}
 
-		file, err := os.Create(filePath)
-		if err != nil {
-			return err
+// ensureCalendarProperties adds the VERSION and PRODID that RFC 5545
+// requires if a file written by another program left them out.
+func ensureCalendarProperties(cal *ics.Calendar) {
+	version, prodID := false, false
+	for _, p := range cal.CalendarProperties {
+		switch p.IANAToken {
+		case string(ics.PropertyVersion):
+			version = true
+		case string(ics.PropertyProductId):
+			prodID = true
 		}
-		defer file.Close()
+	}
+	if !version {
+		cal.SetVersion("2.0")
+	}
+	if !prodID {
+		cal.SetProductId("-//arran4//Golang ICS Library") // As ics.NewCalendar
+	}
+}
+
+// writeCalendarFile atomically replaces filePath with the serialized
+// calendar, unless it breaks RFC 5545. original is the file the calendar was
+// read from, nil for a new file; unchanged properties are written exactly as
+// they were in it. uid is the todo being saved, "" if none was changed.
+func writeCalendarFile(filePath string, cal *ics.Calendar, original []byte, uid string) error {
+	data := serializeCalendar(cal, original)
+	if err := validateCalendar(data, uid); err != nil {
+		return fmt.Errorf("not writing invalid calendar: %v", err)
+	}
+	return writeFileAtomic(filePath, data)
+}
 
-		if err := cal.SerializeTo(file); err != nil {
-			return fmt.Errorf("error saving todo %s: %v", todo.UID, err)
+// writeFileAtomic replaces filePath with data. The data is written to a
+// temporary file in the same directory, synced to disk and then renamed over
+// the original, so a crash or a full disk never leaves a truncated file
+// behind. The original file mode is kept.
+func writeFileAtomic(filePath string, data []byte) (err error) {
+	mode := os.FileMode(0644)
+	if info, err := os.Stat(filePath); err == nil {
+		mode = info.Mode().Perm()
+	}
+
+	dir := filepath.Dir(filePath)
+	tmp, err := os.CreateTemp(dir, "."+filepath.Base(filePath)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			tmp.Close()
+			os.Remove(tmp.Name())
 		}
+	}()
 
-		todo.Modified = false // Reset the modified flag after saving
+	if _, err = tmp.Write(data); err != nil {
+		return err
+	}
+	if err = tmp.Chmod(mode); err != nil {
+		return err
+	}
+	if err = tmp.Sync(); err != nil {
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+	if err = os.Rename(tmp.Name(), filePath); err != nil {
+		return err
 	}
 
+	// Make the rename itself durable. Not all platforms support syncing a
+	// directory, so failures here are ignored.
+	if d, err := os.Open(dir); err == nil {
+		d.Sync()
+		d.Close()
+	}
 	return nil
 }
 
+// todoFileName returns a safe file name for a new todo derived from its UID.
+// Characters that are not valid or portable in file names are replaced.
+func todoFileName(uid string) string {
+	name := strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+			return r
+		case r == '-', r == '_', r == '.':
+			return r
+		}
+		return '_'
+	}, uid)
+	name = strings.TrimLeft(name, ".")
+	if name == "" {
+		name = generateUID()
+	}
+	return name + ".ics"
+}
+
+// setPropertyIfNotEmpty sets a property, keeping its parameters if it is
+// already there, or removes it if value is empty.
 func setPropertyIfNotEmpty(vtodo *ics.VTodo, property ics.ComponentProperty, value string) {
-	if value != "" {
-		vtodo.SetProperty(property, value)
-	} else {
+	if value == "" {
 		removeProperty(vtodo, property)
+		return
+	}
+	if prop := vtodo.GetProperty(property); prop != nil {
+		prop.Value = value
+	} else {
+		vtodo.AddProperty(property, value)
 	}
 }
 
@@ -302,63 +574,119 @@ func removeProperty(vtodo *ics.VTodo, property ics.ComponentProperty) {
 	}
 }
 
-func addItem(todoList *TodoList) {
-	// Add new todo item
+// newTodo returns an unsaved todo with the configured default priority and
+// categories.
+func newTodo(parentUID string) *Todo {
 	todo := &Todo{
-		UID:     generateUID(),
-		Created: time.Now(),
-		LastMod: time.Now(),
-		Status:  "NEEDS-ACTION", // Set default status
+		UID:       generateUID(),
+		Created:   time.Now(),
+		LastMod:   time.Now(),
+		Status:    "NEEDS-ACTION", // Set default status
+		ParentUID: parentUID,
+		Priority:  *defaultPriorityPtr,
 	}
+	for _, cat := range strings.Split(*defaultCategoriesPtr, ",") {
+		if cat = strings.TrimSpace(cat); cat != "" {
+			todo.Categories = append(todo.Categories, cat)
+		}
+	}
+	return todo
+}
 
-	var e error
-	todo.Summary, e = display("", "Todo Title: ")
+func addItem(todoList *TodoList, parentUID string) {
+	todo := newTodo(parentUID)
+	title, e := menu.Prompt("Todo Title (add ? to review): ", "")
 	if e != nil {
 		return
 	}
 
-	if todo.Summary != "" {
-		editItem(todo, todoList)
-		if todo.Summary != "" && todo.Modified {
-			todo.LastMod = time.Now() // Update LastMod when adding
-			todoList.Todos = append(todoList.Todos, todo)
+	// Quick-add tokens like (A), @cat and due:fri fill in the other fields
+	review := applyQuickAdd(todo, title, time.Now())
+	if todo.Summary == "" {
+		return
+	}
+	collection, err := chooseCollection(todoList, parentUID)
+	if err != nil {
+		if !errors.Is(err, errEscape) {
+			log.Print(err)
 		}
+		return
+	}
+	todo.Collection = collection
+	if !review {
+		// Save right away; if that fails it is tried again on exit
+		todo.Modified = true
+		todoList.Todos = append(todoList.Todos, todo)
+		if err := saveTodo(todo, todoDir(todo)); err != nil {
+			log.Printf("Error saving %s: %v", todo.Summary, err)
+			return
+		}
+		todo.Modified = false
+		return
+	}
+
+	editItem(todo, todoList)
+	if todo.Summary != "" && todo.Modified {
+		todo.LastMod = time.Now() // Update LastMod when adding
+		todoList.Todos = append(todoList.Todos, todo)
 	}
 }
 
 func editItem(todo *Todo, todoList *TodoList) {
 	originalTodo := *todo   // Make a copy of the original todo
 	isNew := todo.UID == "" // Check if this is a new item
+	// Subtasks completed along with the todo, as they were before
+	originalSubtasks := make(map[*Todo]Todo)
 	for edit := true; edit; {
 		var displayList strings.Builder
-		var tdd string
-		if todo.DueDate.IsZero() {
-			tdd = ""
-		} else {
-			tdd = todo.DueDate.Format("2006-01-02")
+		tdd := formatDate(todo.DueDate)
+		var tdt, tst string
+		if todo.DueKind != DateOnly {
+			tdt = formatTime(todo.DueDate)
+		}
+		if todo.StartKind != DateOnly {
+			tst = formatTime(todo.StartDate)
 		}
 		var comp string
 		if len(todo.Summary) == 0 {
 			comp = ""
-		} else if todo.Status == "COMPLETED" {
+		} else if !isOpen(todo) {
 			comp = "Restore item (uncomplete)\n\n"
 		} else {
 			comp = "Complete item\n\n"
 		}
+		var subtask string
+		if findTodo(todoList, todo.UID) == todo {
+			subtask = "Add subtask\n"
+		}
+		var moveList string
+		if len(todoList.Collections) > 1 {
+			moveList = fmt.Sprintf("Move to list: %s\n", listName(todo))
+		}
 		fmt.Fprintf(&displayList,
 			"Save item\n%s"+
 				"Title: %s\n"+
 				"Priority: %d\n"+
+				"Status: %s\n"+
+				"Progress: %d%%\n"+
 				"Categories (comma separated): %s\n"+
 				"Due date yyyy-mm-dd: %s\n"+
+				"Due time hh:mm: %s\n"+
 				"Start date yyyy-mm-dd: %s\n"+
 				"Start time hh:mm: %s\n"+
+				"Repeat: %s\n"+
+				"Set parent: %s\n"+
+				"Alarms: %d\n"+
 				"Description: %s\n\n"+
+				"%s%s"+
 				"Delete item",
-			comp, todo.Summary, todo.Priority, strings.Join(todo.Categories, ","),
-			tdd, formatDate(todo.StartDate), formatTime(todo.StartDate), todo.Description,
+			comp, todo.Summary, todo.Priority, todo.Status, todo.Percent, strings.Join(todo.Categories, ","),
+			tdd, tdt, formatDate(todo.StartDate), tst,
+			formatRRule(todo.RRule), parentSummary(todo, todoList), len(todo.Alarms),
+			todo.Description,
+			moveList, subtask,
 		)
-		out, e := display(displayList.String(), todo.Summary)
+		out, e := menu.Show(displayList.String(), todo.Summary)
 		// Cancel new item if ESC is hit without saving
 		if e != nil {
 			if isNew {
@@ -367,6 +695,9 @@ func editItem(todo *Todo, todoList *TodoList) {
 			} else {
 				*todo = originalTodo // Revert changes for existing item
 			}
+			for sub, original := range originalSubtasks {
+				*sub = original
+			}
 			return
 		}
 		switch {
@@ -375,13 +706,13 @@ func editItem(todo *Todo, todoList *TodoList) {
 			todo.LastMod = time.Now() // Update LastMod when saving
 			edit = false
 		case strings.HasPrefix(out, "Title"):
-			tn, e := display(todo.Summary, "Todo Title: ")
+			tn, e := menu.Prompt("Todo Title: ", todo.Summary)
 			if e == nil {
 				todo.Summary = tn
 				todo.Modified = true // Set the modified flag
 			}
 		case strings.HasPrefix(out, "Priority"):
-			p, e := display(fmt.Sprintf("%d", todo.Priority), "Priority (0-9, 0 to unset):")
+			p, e := menu.Prompt("Priority (0-9, 0 to unset):", strconv.Itoa(todo.Priority))
 			if e == nil {
 				pn, err := strconv.Atoi(p)
 				if err == nil && pn >= 0 && pn <= 9 {
@@ -392,16 +723,20 @@ func editItem(todo *Todo, todoList *TodoList) {
 					}
 					todo.Modified = true
 				} else {
-					display("", "Priority must be a number between 0 and 9")
+					menu.Show("", "Priority must be a number between 0 and 9")
 				}
 			}
+		case strings.HasPrefix(out, "Status"):
+			editStatus(todo)
+		case strings.HasPrefix(out, "Progress"):
+			editPercent(todo)
 		case strings.HasPrefix(out, "Categories"):
 			existingCats := getExistingCategories(todoList)
 			catOptions := strings.Join(existingCats, "\n") + "\n<Enter new category>"
-			cats, e := display(catOptions, "Select or enter new category (comma separated):")
+			cats, e := menu.Show(catOptions, "Select or enter new category (comma separated):")
 			if e == nil {
 				if cats == "<Enter new category>" {
-					newCats, _ := display("", "Enter new category (comma separated):")
+					newCats, _ := menu.Prompt("Enter new category (comma separated):", "")
 					todo.Categories = strings.Split(newCats, ",")
 				} else {
 					todo.Categories = strings.Split(cats, ",")
@@ -415,94 +750,297 @@ func editItem(todo *Todo, todoList *TodoList) {
 				todo.Modified = true
 			}
 		case strings.HasPrefix(out, "Due date"):
-			d, e := display(tdd, "Due Date (yyyy-mm-dd):")
+			d, e := menu.Show(dateOptions(tdd), "Due Date (yyyy-mm-dd, tomorrow, fri, +3d...):")
 			if e == nil {
-				if d == "" {
-					todo.DueDate = time.Time{} // Clear the due date
-				} else {
-					td, err := time.ParseInLocation("2006-01-02", d, time.Local)
-					if err != nil {
-						display("", "Bad date format. Should be yyyy-mm-dd.")
-					} else {
-						todo.DueDate = td
-						todo.Modified = true
-					}
+				if !updateDueDate(todo, d) {
+					menu.Show("", "Bad date format. Try yyyy-mm-dd, tomorrow, next mon or +3d.")
 				}
 			}
+		case strings.HasPrefix(out, "Due time"):
+			t, e := menu.Prompt("Due Time (hh:mm or hhmm, empty for all day):", tdt)
+			if e == nil {
+				updateDueTime(todo, t)
+			}
 		case strings.HasPrefix(out, "Start date"):
-			d, e := display(formatDate(todo.StartDate), "Start Date (yyyy-mm-dd):")
+			d, e := menu.Show(dateOptions(formatDate(todo.StartDate)), "Start Date (yyyy-mm-dd, tomorrow, fri, +3d...):")
 			if e == nil {
-				updateStartDate(todo, d)
+				if !updateStartDate(todo, d) {
+					menu.Show("", "Bad date format. Try yyyy-mm-dd, tomorrow, next mon or +3d.")
+				}
 			}
 		case strings.HasPrefix(out, "Start time"):
-			t, e := display(formatTime(todo.StartDate), "Start Time (hh:mm or hhmm):")
+			t, e := menu.Prompt("Start Time (hh:mm or hhmm):", tst)
 			if e == nil {
 				updateStartTime(todo, t)
 			}
+		case strings.HasPrefix(out, "Repeat"):
+			editRepeat(todo)
+		case strings.HasPrefix(out, "Set parent"):
+			setParent(todo, todoList)
+		case strings.HasPrefix(out, "Alarms"):
+			editAlarms(todo)
+		case out == "Add subtask":
+			addSubtask(todo, todoList)
+		case strings.HasPrefix(out, "Move to list"):
+			moveToList(todo, todoList)
 		case strings.HasPrefix(out, "Description"):
-			desc, e := display(todo.Description, "Description:")
+			desc, e := menu.Prompt("Description:", todo.Description)
 			if e == nil {
 				todo.Description = desc
 				todo.Modified = true // Set the modified flag
 			}
 		case strings.HasPrefix(out, "Complete item"):
-			todo.Status = "COMPLETED"
-			todo.LastMod = time.Now()
-			todo.Modified = true // Set the modified flag
+			for _, sub := range openDescendants(todoList, todo) {
+				if _, ok := originalSubtasks[sub]; !ok {
+					originalSubtasks[sub] = *sub
+				}
+			}
+			completeItem(todo, todoList)
 		case strings.HasPrefix(out, "Restore item"):
-			todo.Status = "NEEDS-ACTION"
-			todo.LastMod = time.Now()
-			todo.Modified = true // Set the modified flag
+			setStatus(todo, "NEEDS-ACTION", time.Now())
 		case strings.HasPrefix(out, "Delete item"):
-			confirm, _ := display("", fmt.Sprintf("Delete item: %s. y/N?", todo.Summary))
-			if strings.ToLower(confirm) == "y" {
-				if deleteTodo(todo, todoList) {
-					edit = false
-					return
-				}
+			if deleteItem(todo, todoList) {
+				return
+			}
+		}
+	}
+}
+
+// completeItem completes a todo, asking first whether its open subtasks
+// should be completed too.
+func completeItem(todo *Todo, todoList *TodoList) {
+	cascade, ok := confirmOpenSubtasks(todo, todoList, "Complete")
+	if !ok {
+		return
+	}
+	if cascade {
+		for _, t := range openDescendants(todoList, todo) {
+			completeTodo(t)
+		}
+	}
+	completeTodo(todo)
+}
+
+// deleteItem asks for confirmation and deletes a todo, along with its open
+// subtasks if the user chooses. It returns true if the todo was deleted.
+func deleteItem(todo *Todo, todoList *TodoList) bool {
+	if !menu.Confirm(fmt.Sprintf("Delete item: %s?", todo.Summary)) {
+		return false
+	}
+	cascade, ok := confirmOpenSubtasks(todo, todoList, "Delete")
+	if !ok {
+		return false
+	}
+	todos := []*Todo{todo}
+	if cascade {
+		todos = append(todos, openDescendants(todoList, todo)...)
+	}
+	return deleteTodos(todos, todoList)[todo.UID]
+}
+
+func editRepeat(todo *Todo) {
+	var options strings.Builder
+	options.WriteString("Does not repeat\n")
+	for _, p := range rrulePresets {
+		options.WriteString(p.Name + "\n")
+	}
+	options.WriteString("Every N days\nCustom RRULE")
+	out, e := menu.Show(options.String(), "Repeat:")
+	if e != nil {
+		return
+	}
+	var rule string
+	switch out {
+	case "Does not repeat":
+		rule = ""
+	case "Every N days":
+		n, e := menu.Prompt("Repeat every how many days?", "")
+		if e != nil {
+			return
+		}
+		days, err := strconv.Atoi(n)
+		if err != nil || days < 1 {
+			menu.Show("", "Number of days must be a positive number")
+			return
+		}
+		rule = fmt.Sprintf("FREQ=DAILY;INTERVAL=%d", days)
+	case "Custom RRULE":
+		r, e := menu.Prompt("RRULE (e.g. FREQ=MONTHLY;BYDAY=1MO):", todo.RRule)
+		if e != nil {
+			return
+		}
+		rule = strings.TrimPrefix(strings.TrimSpace(r), "RRULE:")
+	default:
+		found := false
+		for _, p := range rrulePresets {
+			if p.Name == out {
+				rule, found = p.Rule, true
 			}
 		}
+		if !found {
+			return
+		}
+	}
+	if rule != "" {
+		if _, err := parseRRule(rule); err != nil {
+			menu.Show("", fmt.Sprintf("Bad repeat rule: %v", err))
+			return
+		}
+	}
+	todo.RRule = rule
+	todo.Modified = true
+}
+
+func formatRRule(rrule string) string {
+	if rrule == "" {
+		return ""
+	}
+	rule, err := parseRRule(rrule)
+	if err != nil {
+		return rrule
+	}
+	return rule.Summary()
+}
+
+// completeTodo marks a todo as done, or advances it to the next occurrence
+// if it recurs.
+func completeTodo(todo *Todo) {
+	if advanceRecurrence(todo) {
+		// The next occurrence starts over
+		todo.Percent = 0
+		if todo.Status == "IN-PROCESS" {
+			todo.Status = "NEEDS-ACTION"
+		}
+	} else {
+		setStatus(todo, "COMPLETED", time.Now())
 	}
+	todo.LastMod = time.Now()
+	todo.Modified = true // Set the modified flag
 }
 
-func updateStartDate(todo *Todo, dateStr string) {
+// dateOptions returns the current value followed by the date shortcuts for
+// a date prompt.
+func dateOptions(current string) string {
+	options := strings.Join(dateShortcuts, "\n")
+	if current != "" {
+		options = current + "\n" + options
+	}
+	return options
+}
+
+// updateDueDate sets the due date from anything parseDateInput accepts. The
+// time of day is kept if one was set and the input has none, otherwise new
+// due dates are all-day. It returns false if dateStr can't be parsed.
+func updateDueDate(todo *Todo, dateStr string) bool {
 	if dateStr == "" {
-		todo.StartDate = time.Time{}
+		todo.DueDate = time.Time{} // Clear the due date
+		todo.DueKind, todo.DueTZID = DateTimeUTC, ""
+		todo.Modified = true
+		return true
+	}
+	date, hasTime, err := parseDateInput(dateStr, time.Now())
+	if err != nil {
+		return false
+	}
+	if hasTime {
+		if todo.DueKind == DateOnly {
+			todo.DueKind = DateTimeUTC
+		}
+		todo.DueDate = date
+	} else if !todo.DueDate.IsZero() && todo.DueKind != DateOnly {
+		todo.DueDate = time.Date(date.Year(), date.Month(), date.Day(),
+			todo.DueDate.Hour(), todo.DueDate.Minute(), 0, 0, time.Local)
 	} else {
-		date, err := time.ParseInLocation("2006-01-02", dateStr, time.Local)
-		if err == nil {
-			if !todo.StartDate.IsZero() {
-				todo.StartDate = time.Date(date.Year(), date.Month(), date.Day(),
-					todo.StartDate.Hour(), todo.StartDate.Minute(), 0, 0, time.Local)
-			} else {
-				todo.StartDate = date
-			}
+		todo.DueDate = date
+		todo.DueKind, todo.DueTZID = DateOnly, ""
+	}
+	todo.Modified = true
+	return true
+}
+
+// updateDueTime sets the time of day of the due date. An empty time makes
+// the due date all-day again.
+func updateDueTime(todo *Todo, timeStr string) {
+	if timeStr == "" {
+		if !todo.DueDate.IsZero() && todo.DueKind != DateOnly {
+			todo.DueDate = time.Date(todo.DueDate.Year(), todo.DueDate.Month(), todo.DueDate.Day(),
+				0, 0, 0, 0, time.Local)
+			todo.DueKind, todo.DueTZID = DateOnly, ""
 			todo.Modified = true
 		}
+		return
+	}
+	hour, min, ok := parseClock(timeStr)
+	if !ok {
+		return
 	}
+	if todo.DueDate.IsZero() {
+		todo.DueDate = time.Now().Local()
+	}
+	todo.DueDate = time.Date(todo.DueDate.Year(), todo.DueDate.Month(), todo.DueDate.Day(),
+		hour, min, 0, 0, time.Local)
+	if todo.DueKind == DateOnly {
+		todo.DueKind = DateTimeUTC
+	}
+	todo.Modified = true
+}
+
+// updateStartDate works like updateDueDate for the start date.
+func updateStartDate(todo *Todo, dateStr string) bool {
+	if dateStr == "" {
+		todo.StartDate = time.Time{}
+		todo.StartKind, todo.StartTZID = DateTimeUTC, ""
+		todo.Modified = true
+		return true
+	}
+	date, hasTime, err := parseDateInput(dateStr, time.Now())
+	if err != nil {
+		return false
+	}
+	if hasTime {
+		if todo.StartKind == DateOnly {
+			todo.StartKind = DateTimeUTC
+		}
+		todo.StartDate = date
+	} else if !todo.StartDate.IsZero() {
+		todo.StartDate = time.Date(date.Year(), date.Month(), date.Day(),
+			todo.StartDate.Hour(), todo.StartDate.Minute(), 0, 0, time.Local)
+	} else {
+		todo.StartDate = date
+		todo.StartKind, todo.StartTZID = DateOnly, ""
+	}
+	todo.Modified = true
+	return true
 }
 
 func updateStartTime(todo *Todo, timeStr string) {
 	if timeStr == "" {
 		return
 	}
-	var hour, min int
-	var err error
-	if strings.Contains(timeStr, ":") {
-		_, err = fmt.Sscanf(timeStr, "%d:%d", &hour, &min)
-	} else {
-		_, err = fmt.Sscanf(timeStr, "%02d%02d", &hour, &min)
-	}
-	if err == nil && hour >= 0 && hour < 24 && min >= 0 && min < 60 {
+	if hour, min, ok := parseClock(timeStr); ok {
 		if todo.StartDate.IsZero() {
 			todo.StartDate = time.Now().Local()
 		}
 		todo.StartDate = time.Date(todo.StartDate.Year(), todo.StartDate.Month(), todo.StartDate.Day(),
 			hour, min, 0, 0, time.Local)
+		if todo.StartKind == DateOnly {
+			todo.StartKind = DateTimeUTC
+		}
 		todo.Modified = true
 	}
 }
 
+// parseClock parses a time of day given as hh:mm or hhmm.
+func parseClock(timeStr string) (hour, min int, ok bool) {
+	var err error
+	if strings.Contains(timeStr, ":") {
+		_, err = fmt.Sscanf(timeStr, "%d:%d", &hour, &min)
+	} else {
+		_, err = fmt.Sscanf(timeStr, "%02d%02d", &hour, &min)
+	}
+	return hour, min, err == nil && hour >= 0 && hour < 24 && min >= 0 && min < 60
+}
+
 func formatDate(t time.Time) string {
 	if t.IsZero() {
 		return ""
@@ -518,6 +1056,20 @@ func formatTime(t time.Time) string {
 }
 
 func deleteTodo(todo *Todo, todoList *TodoList) bool {
+	// Delete the todo from its .ics file, or the file if nothing else is in
+	// it. A todo that was never saved has no file.
+	if todo.FileName != "" {
+		filePath := filepath.Join(todoDir(todo), todo.FileName)
+		if todo.MovedFrom != "" {
+			// Moved to another list but not saved there yet
+			filePath = todo.MovedFrom
+		}
+		if err := removeFromFile(filePath, todo.UID); err != nil {
+			log.Printf("Error deleting todo from %s: %v", filePath, err)
+			return false
+		}
+	}
+
 	// Remove the todo from the todoList
 	for i, t := range todoList.Todos {
 		if t.UID == todo.UID {
@@ -525,156 +1077,198 @@ func deleteTodo(todo *Todo, todoList *TodoList) bool {
 			break
 		}
 	}
+	log.Printf("Todo item deleted: %s", todo.Summary)
+	return true
+}
 
-	// Delete the corresponding .ics file
-	filePath := filepath.Join(*todoPtr, todo.UID+".ics")
-	err := os.Remove(filePath)
-	if err != nil {
-		log.Printf("Error deleting file %s: %v", filePath, err)
+// deleteTodos deletes todos, parents before their subtasks, and returns
+// the UIDs deleted. Todos below one that couldn't be deleted are kept.
+// Subtasks that are left move up to their nearest ancestor that is left,
+// once the todos above them are gone.
+func deleteTodos(todos []*Todo, todoList *TodoList) map[string]bool {
+	parents := make(map[string]string)
+	for _, todo := range todoList.Todos {
+		parents[todo.UID] = todo.ParentUID
+	}
+	deleted := make(map[string]bool)
+	failed := make(map[string]bool)
+	belowFailed := func(uid string) bool {
+		for seen := map[string]bool{}; uid != "" && !seen[uid]; uid = parents[uid] {
+			seen[uid] = true // Guard against RELATED-TO cycles
+			if failed[uid] {
+				return true
+			}
+		}
 		return false
 	}
-
-	log.Printf("Todo item deleted: %s", todo.Summary)
-	return true
+	for _, todo := range todos {
+		if belowFailed(todo.ParentUID) || !deleteTodo(todo, todoList) {
+			failed[todo.UID] = true
+			continue
+		}
+		deleted[todo.UID] = true
+	}
+	for _, todo := range todoList.Todos {
+		parent := todo.ParentUID
+		for seen := map[string]bool{}; deleted[parent] && !seen[parent]; {
+			seen[parent] = true
+			parent = parents[parent]
+		}
+		if parent != todo.ParentUID {
+			todo.ParentUID = parent
+			todo.Modified = true
+		}
+	}
+	return deleted
 }
 
-func viewCompletedItems(todoList *TodoList) {
+// viewClosedItems shows the completed or cancelled items.
+func viewClosedItems(todoList *TodoList, kind listKind) {
+	name := "Completed"
+	if kind == cancelledItems {
+		name = "Cancelled"
+	}
 	for {
-		displayList, m := createMenu(todoList, true)
-		out, _ := display(displayList.String(), "Completed Items")
-
-		if out == "Delete All Completed" {
-			confirm, _ := display("", "Delete ALL Completed Items? (y/N)")
-			if strings.ToLower(confirm) == "y" {
-				var completedTodos []*Todo
-				var remainingTodos []*Todo
-
-				// First, separate completed and non-completed todos
-				for _, todo := range todoList.Todos {
-					if todo.Status == "COMPLETED" {
-						completedTodos = append(completedTodos, todo)
-					} else {
-						remainingTodos = append(remainingTodos, todo)
-					}
-				}
-
-				// Now delete all completed todos
-				for _, todo := range completedTodos {
-					if deleteTodo(todo, todoList) {
-						log.Printf("Deleted completed item: %s", todo.Summary)
-					} else {
-						// If deletion failed, keep the todo in the list
-						remainingTodos = append(remainingTodos, todo)
-					}
-				}
-
-				todoList.Todos = remainingTodos
+		list := createMenu(todoList, kind)
+		out, _ := menu.Show(list.String(), name+" Items")
+
+		if out == "Delete All "+name {
+			if menu.Confirm("Delete ALL " + name + " Items?") {
+				// Only the items shown, not those hidden by the list filter or
+				// the filter query
+				deleteTodos(visibleTodos(todoList, kind), todoList)
 			}
 			return
 		} else if out != "" {
-			t := todoList.Todos[m[out]]
-			editItem(t, todoList)
+			if todo := list.lookup(out); todo != nil {
+				editItem(todo, todoList)
+			}
 		} else {
 			return
 		}
 	}
 }
 
-func display(list string, title string) (result string, e error) {
-	var out, outErr bytes.Buffer
-	flag.Parse()
-	userOpts := strings.Split(*optsPtr, " ")
+// menuList is the lines of a menu and the todo each line stands for, nil
+// for the header lines. A todo line that would be identical to an earlier
+// one gets an invisible zero width space suffix so they can be told apart.
+type menuList struct {
+	lines []string
+	todos []*Todo
+	seen  map[string]bool
+}
 
-	// Default options for supported launchers
-	defaultOpts := []string{"-i", "-p", title}
-	switch *cmdPtr {
-	case "rofi":
-		defaultOpts = []string{"-i", "-dmenu", "-p", title}
-	case "wofi", "fuzzel":
-		defaultOpts = []string{"-i", "--dmenu", "-p", title}
-	case "tofi":
-		defaultOpts = []string{"-i", "--prompt-text", title}
+func (l *menuList) add(line string, todo *Todo) {
+	if l.seen == nil {
+		l.seen = make(map[string]bool)
+	}
+	for todo != nil && l.seen[line] {
+		line += "\u200b"
 	}
+	l.seen[line] = true
+	l.lines = append(l.lines, line)
+	l.todos = append(l.todos, todo)
+}
 
-	// Combine default options with user options
-	opts := append(defaultOpts, userOpts...)
+func (l *menuList) String() string {
+	return strings.Join(l.lines, "\n")
+}
 
-	// Remove empty strings from opts
-	var finalOpts []string
-	for _, opt := range opts {
-		if opt != "" {
-			finalOpts = append(finalOpts, opt)
+// todoLines returns the lines that stand for todos.
+func (l *menuList) todoLines() []string {
+	var lines []string
+	for i, line := range l.lines {
+		if l.todos[i] != nil {
+			lines = append(lines, line)
 		}
 	}
+	return lines
+}
 
-	cmd := exec.Command(*cmdPtr, finalOpts...)
-	cmd.Stdout = &out
-	cmd.Stderr = &outErr
-	cmd.Stdin = strings.NewReader(list)
-	err := cmd.Run()
-	if err != nil {
-		if outErr.String() != "" {
-			log.Fatal(outErr.String())
-		} else {
-			// Skip this error when hitting Esc to go back to previous menu
-			if err.Error() == "exit status 1" {
-				return "", errors.New("escape")
-			}
-			log.Fatal(err.Error())
+// lookup returns the todo for a line the launcher returned, or nil. Lines
+// are matched exactly, then ignoring surrounding whitespace in case the
+// launcher trimmed it.
+func (l *menuList) lookup(out string) *Todo {
+	for i, line := range l.lines {
+		if line == out {
+			return l.todos[i]
 		}
 	}
-	result = strings.TrimRight(out.String(), "\n")
-	return
-}
-
-func createMenu(todoList *TodoList, showCompleted bool) (*strings.Builder, map[string]int) {
-	displayList := &strings.Builder{}
-	if !showCompleted {
-		displayList.WriteString("Add Item\n")
-		displayList.WriteString("View Completed Items\n")
-	} else {
-		displayList.WriteString("Delete All Completed\n")
+	out = strings.TrimSpace(out)
+	for i, line := range l.lines {
+		if strings.TrimSpace(line) == out {
+			return l.todos[i]
+		}
 	}
+	return nil
+}
 
-	// Updated sorting logic
-	sort.Slice(todoList.Todos, func(i, j int) bool {
-		a, b := todoList.Todos[i], todoList.Todos[j]
-
-		// 1. Items with due date come first
-		if !a.DueDate.IsZero() && b.DueDate.IsZero() {
-			return true
+// createMenu returns the main list for openItems, or the screen listing the
+// completed or cancelled items.
+func createMenu(todoList *TodoList, kind listKind) *menuList {
+	list := &menuList{}
+	switch kind {
+	case openItems:
+		addViewLines(list)
+		list.add("Add Item", nil)
+		list.add("View Completed Items", nil)
+		if hasCancelled(todoList) {
+			list.add("View Cancelled Items", nil)
 		}
-		if a.DueDate.IsZero() && !b.DueDate.IsZero() {
-			return false
-		}
-
-		// 2. Sort by due date (ascending)
-		if !a.DueDate.IsZero() && !b.DueDate.IsZero() {
-			return a.DueDate.Before(b.DueDate)
+		list.add("Bulk Edit", nil)
+		if q := filterQuery(); q != "" {
+			list.add("Filter: "+q, nil)
+		} else {
+			list.add("Filter…", nil)
 		}
-
-		// 3. Priority (lower number = higher priority, 0 means no priority)
-		if a.Priority != b.Priority {
-			if a.Priority == 0 {
-				return false
+		if len(todoList.Collections) > 1 {
+			shown := *listFilterPtr
+			if shown == "" {
+				shown = "All"
 			}
-			if b.Priority == 0 {
-				return true
-			}
-			return a.Priority < b.Priority
+			list.add("Lists: "+shown, nil)
 		}
+	case completedItems:
+		list.add("Delete All Completed", nil)
+	case cancelledItems:
+		list.add("Delete All Cancelled", nil)
+	}
 
-		// 4. Created date (descending)
-		return a.Created.After(b.Created)
-	})
+	sortTodos(todoList)
 
-	m := make(map[string]int)
+	// Show subtasks indented below their parent
+	ordered, depths := treeOrder(visibleTodos(todoList, kind))
+	if *groupPtr == "" {
+		for n, todo := range ordered {
+			list.add(formatTodoLine(todoList, todo, depths[n]), todo)
+		}
+		return list
+	}
+	for _, g := range groupTodos(*groupPtr, ordered, depths, time.Now()) {
+		list.add(groupHeader(g.Name), nil)
+		for n, todo := range g.Todos {
+			list.add(formatTodoLine(todoList, todo, g.Depths[n]), todo)
+		}
+	}
+	return list
+}
+
+// visibleTodos returns the open, completed or cancelled todos in the lists
+// picked with -list that match the filter, hiding items before their
+// threshold date if that option is set. A filter on status shows closed
+// items in the open list too.
+func visibleTodos(todoList *TodoList, kind listKind) []*Todo {
 	now := time.Now()
-	for i, todo := range todoList.Todos {
-		if (todo.Status == "COMPLETED") != showCompleted {
+	byStatus := activeFilter != nil && activeFilter.hasField("status") && kind == openItems
+	var visible []*Todo
+	for _, todo := range todoList.Todos {
+		if kindOf(todo) != kind && !byStatus || !inListFilter(todo) {
 			continue
 		}
-		if *thresholdPtr && !showCompleted {
+		if activeFilter != nil && !activeFilter.Match(todo, now) {
+			continue
+		}
+		if *thresholdPtr && kind == openItems {
 			if !todo.StartDate.IsZero() {
 				nowInStartTZ := now.In(todo.StartDate.Location())
 				if todo.StartDate.After(nowInStartTZ) {
@@ -682,43 +1276,73 @@ func createMenu(todoList *TodoList, showCompleted bool) (*strings.Builder, map[s
 				}
 			}
 		}
+		visible = append(visible, todo)
+	}
+	return visible
+}
 
-		// Format: "(priority) created-date summary @category due:due date"
-		var displayStr strings.Builder
-
-		// Priority
-		if todo.Priority > 0 {
-			fmt.Fprintf(&displayStr, "(%d) ", todo.Priority)
-		} else {
-			displayStr.WriteString("    ")
+// formatTodoLine renders a todo for the list view with the -format template
+// or the built-in layout. depth is the subtask nesting level.
+func formatTodoLine(todoList *TodoList, todo *Todo, depth int) string {
+	if lineTemplate != nil {
+		line, err := formatTemplateLine(todoList, todo, depth)
+		if err == nil {
+			return line
 		}
+		log.Printf("Error in -format template, using the default layout: %v", err)
+		lineTemplate = nil
+	}
 
-		// Created date (only if not hidden)
-		if !*hideCreatedDatePtr {
-			fmt.Fprintf(&displayStr, "%s ", todo.Created.Format("2006-01-02"))
-		}
+	// Format: "(priority) created-date summary @category due:due date"
+	var displayStr strings.Builder
 
-		// Summary
-		displayStr.WriteString(todo.Summary)
+	// Priority
+	if todo.Priority > 0 {
+		fmt.Fprintf(&displayStr, "(%d) ", todo.Priority)
+	} else {
+		displayStr.WriteString("    ")
+	}
 
-		// Category
-		if len(todo.Categories) > 0 {
-			for _, category := range todo.Categories {
-				fmt.Fprintf(&displayStr, " @%s", category)
-			}
-		}
+	// Created date (only if not hidden)
+	if !*hideCreatedDatePtr {
+		fmt.Fprintf(&displayStr, "%s ", todo.Created.Format("2006-01-02"))
+	}
+
+	// Summary
+	if depth > 0 {
+		fmt.Fprintf(&displayStr, "%s↳ ", strings.Repeat("  ", depth-1))
+	}
+	displayStr.WriteString(todo.Summary)
 
-		// Due date (convert to local time for display)
-		if !todo.DueDate.IsZero() {
-			localDueDate := todo.DueDate.In(time.Local)
-			fmt.Fprintf(&displayStr, " due:%s", localDueDate.Format("2006-01-02"))
+	// Category
+	if len(todo.Categories) > 0 {
+		for _, category := range todo.Categories {
+			fmt.Fprintf(&displayStr, " @%s", category)
 		}
+	}
+
+	// Due date (convert to local time for display)
+	if !todo.DueDate.IsZero() {
+		localDueDate := todo.DueDate.In(time.Local)
+		fmt.Fprintf(&displayStr, " due:%s", localDueDate.Format("2006-01-02"))
+	}
+
+	// Progress
+	if p := formatPercent(todo); p != "" && todo.Status != "COMPLETED" {
+		displayStr.WriteString(" " + p)
+	}
+
+	// Recurrence
+	if todo.RRule != "" {
+		fmt.Fprintf(&displayStr, " ↻ %s", formatRRule(todo.RRule))
+	}
 
-		displayList.WriteString(displayStr.String() + "\n")
-		m[displayStr.String()] = i
+	// List, when there is more than one
+	if len(todoList.Collections) > 1 && todo.Collection != nil {
+		fmt.Fprintf(&displayStr, " [%s]", todo.Collection.Name)
 	}
 
-	return displayList, m
+	return displayStr.String()
 }
 
 func generateUID() string {
@@ -2,6 +2,7 @@ package main
 
 import (
 	"os"
+	"path/filepath"
 	"reflect"
 	"strings"
 	"testing"
@@ -207,8 +208,8 @@ func TestCreateMenu(t *testing.T) {
 		t.Fatalf("Failed to load todos: %v", err)
 	}
 
-	displayList, m := createMenu(todoList, false)
-	menuStr := displayList.String()
+	list := createMenu(todoList, openItems)
+	menuStr := list.String()
 
 	expectedItems := []string{
 		"Add Item",
@@ -221,8 +222,8 @@ func TestCreateMenu(t *testing.T) {
 		}
 	}
 
-	if len(m) != len(todoList.Todos) {
-		t.Errorf("Expected %d items in the menu map, got %d", len(todoList.Todos), len(m))
+	if lines := list.todoLines(); len(lines) != len(todoList.Todos) {
+		t.Errorf("Expected %d todo lines in the menu, got %d", len(todoList.Todos), len(lines))
 	}
 }
 
@@ -299,3 +300,207 @@ func TestEditTodo(t *testing.T) {
 		t.Error("Expected Modified flag to be set to true")
 	}
 }
+
+func TestSaveTodosUsesLoadedFileName(t *testing.T) {
+	todoList, err := loadTodos("testdata")
+	if err != nil {
+		t.Fatalf("Failed to load todos: %v", err)
+	}
+
+	todo := findTodoByUID(todoList, "20240918T131500Z-test2@example.com")
+	if todo == nil {
+		t.Fatal("Todo with UID 20240918T131500Z-test2@example.com not found")
+	}
+	if todo.FileName != "nmo5.ics" {
+		t.Fatalf("Expected file name nmo5.ics, got %s", todo.FileName)
+	}
+	todo.Summary = "Edited"
+	todo.Modified = true
+
+	tempDir := t.TempDir()
+	if err := saveTodos(todoList, tempDir); err != nil {
+		t.Fatalf("Failed to save todos: %v", err)
+	}
+
+	files, err := os.ReadDir(tempDir)
+	if err != nil {
+		t.Fatalf("Failed to read temp directory: %v", err)
+	}
+	if len(files) != 1 || files[0].Name() != "nmo5.ics" {
+		t.Errorf("Expected only nmo5.ics to be written, got %v", files)
+	}
+}
+
+func TestTodoFileName(t *testing.T) {
+	tests := map[string]string{
+		"35rU":                               "35rU.ics",
+		"20240918T131500Z-test2@example.com": "20240918T131500Z-test2_example.com.ics",
+		"../a/b":                             "_a_b.ics",
+	}
+	for uid, expected := range tests {
+		if got := todoFileName(uid); got != expected {
+			t.Errorf("todoFileName(%q) = %q, expected %q", uid, got, expected)
+		}
+	}
+}
+
+func TestSaveTodosPreservesFileMode(t *testing.T) {
+	tempDir := t.TempDir()
+	filePath := filepath.Join(tempDir, "35rU.ics")
+	data, err := os.ReadFile(filepath.Join("testdata", "35rU.ics"))
+	if err != nil {
+		t.Fatalf("Failed to read test file: %v", err)
+	}
+	if err := os.WriteFile(filePath, data, 0600); err != nil {
+		t.Fatalf("Failed to write test file: %v", err)
+	}
+
+	todoList, err := loadTodos(tempDir)
+	if err != nil {
+		t.Fatalf("Failed to load todos: %v", err)
+	}
+	todoList.Todos[0].Summary = "Edited"
+	todoList.Todos[0].Modified = true
+	if err := saveTodos(todoList, tempDir); err != nil {
+		t.Fatalf("Failed to save todos: %v", err)
+	}
+
+	info, err := os.Stat(filePath)
+	if err != nil {
+		t.Fatalf("Failed to stat saved file: %v", err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("Expected file mode 0600, got %v", info.Mode().Perm())
+	}
+}
+
+func TestSaveTodosContinuesAfterError(t *testing.T) {
+	tempDir := t.TempDir()
+	badPath := filepath.Join(tempDir, "bad.ics")
+	if err := os.WriteFile(badPath, []byte("not a calendar"), 0644); err != nil {
+		t.Fatalf("Failed to write test file: %v", err)
+	}
+
+	bad := &Todo{UID: "bad", Summary: "Bad", FileName: "bad.ics", Modified: true}
+	good := &Todo{UID: "good", Summary: "Good", Modified: true}
+	todoList := &TodoList{Todos: []*Todo{bad, good}}
+
+	if err := saveTodos(todoList, tempDir); err == nil {
+		t.Error("Expected an error for the unparseable file")
+	}
+	if !bad.Modified {
+		t.Error("Expected failed todo to remain modified")
+	}
+	if good.Modified {
+		t.Error("Expected good todo to be saved")
+	}
+
+	data, err := os.ReadFile(badPath)
+	if err != nil || string(data) != "not a calendar" {
+		t.Errorf("Expected bad.ics to be left untouched, got %q", data)
+	}
+	files, err := os.ReadDir(tempDir)
+	if err != nil {
+		t.Fatalf("Failed to read temp directory: %v", err)
+	}
+	if len(files) != 2 {
+		t.Errorf("Expected bad.ics and good.ics only, got %v", files)
+	}
+}
+
+func TestUpdateDueDateAndTime(t *testing.T) {
+	todo := &Todo{}
+	if !updateDueDate(todo, "2024-10-01") {
+		t.Fatal("Failed to set due date")
+	}
+	if todo.DueKind != DateOnly || formatDate(todo.DueDate) != "2024-10-01" {
+		t.Errorf("Expected all-day due 2024-10-01, got %v (kind %v)", todo.DueDate, todo.DueKind)
+	}
+
+	updateDueTime(todo, "14:30")
+	if todo.DueKind == DateOnly || formatTime(todo.DueDate) != "14:30" || formatDate(todo.DueDate) != "2024-10-01" {
+		t.Errorf("Expected due 2024-10-01 14:30, got %v (kind %v)", todo.DueDate, todo.DueKind)
+	}
+
+	// Changing the date keeps the time
+	updateDueDate(todo, "2024-10-05")
+	if todo.DueKind == DateOnly || formatTime(todo.DueDate) != "14:30" || formatDate(todo.DueDate) != "2024-10-05" {
+		t.Errorf("Expected due 2024-10-05 14:30, got %v (kind %v)", todo.DueDate, todo.DueKind)
+	}
+
+	updateDueTime(todo, "")
+	if todo.DueKind != DateOnly || formatTime(todo.DueDate) != "00:00" {
+		t.Errorf("Expected all-day due after clearing time, got %v", todo.DueDate)
+	}
+
+	if updateDueDate(todo, "10/05/2024") {
+		t.Error("Expected bad date format to be rejected")
+	}
+}
+
+func TestSaveAllDayDue(t *testing.T) {
+	tempDir := t.TempDir()
+	todo := &Todo{UID: "allday", Summary: "All day", Modified: true}
+	updateDueDate(todo, "2024-10-01")
+	if err := saveTodos(&TodoList{Todos: []*Todo{todo}}, tempDir); err != nil {
+		t.Fatalf("Failed to save todos: %v", err)
+	}
+
+	data, err := os.ReadFile(filepath.Join(tempDir, "allday.ics"))
+	if err != nil {
+		t.Fatalf("Failed to read saved file: %v", err)
+	}
+	if !strings.Contains(string(data), "DUE;VALUE=DATE:20241001\r\n") {
+		t.Errorf("Expected all-day DUE, got:\n%s", data)
+	}
+
+	todoList, err := loadTodos(tempDir)
+	if err != nil {
+		t.Fatalf("Failed to load todos: %v", err)
+	}
+	if todoList.Todos[0].DueKind != DateOnly || formatDate(todoList.Todos[0].DueDate) != "2024-10-01" {
+		t.Errorf("Expected all-day due to round trip, got %v", todoList.Todos[0].DueDate)
+	}
+}
+
+func TestCreateMenuDuplicateTitles(t *testing.T) {
+	created := time.Date(2024, 9, 18, 0, 0, 0, 0, time.Local)
+	first := &Todo{UID: "one", Summary: "Call mum", Status: "NEEDS-ACTION", Created: created}
+	second := &Todo{UID: "two", Summary: "Call mum", Status: "NEEDS-ACTION", Created: created}
+	todoList := &TodoList{Todos: []*Todo{first, second}}
+
+	list := createMenu(todoList, openItems)
+	lines := list.todoLines()
+	if len(lines) != 2 || lines[0] == lines[1] {
+		t.Fatalf("Expected two distinct lines, got %q", lines)
+	}
+	if strings.TrimRight(lines[1], "\u200b") != lines[0] {
+		t.Errorf("Expected the duplicate to differ only by an invisible suffix, got %q", lines)
+	}
+	found := map[*Todo]bool{list.lookup(lines[0]): true, list.lookup(lines[1]): true}
+	if !found[first] || !found[second] {
+		t.Error("Expected each line to find its own todo")
+	}
+
+	// Launchers that trim whitespace still match
+	if list.lookup("  "+strings.TrimSpace(lines[1])+" ") != list.lookup(lines[1]) {
+		t.Error("Expected a trimmed line to match")
+	}
+	if list.lookup("Call") != nil || list.lookup("Add Item") != nil {
+		t.Error("Expected typed text and headers not to match a todo")
+	}
+
+	// rofi reports the index, which picks the exact duplicate
+	path, _ := fakeLauncher(t, "rofi", "5 "+strings.TrimRight(lines[1], "\u200b")+"\\n", 0)
+	out, err := newMenu(path, "").Show(list.String(), "todos")
+	if err != nil || list.lookup(out) != list.todos[5] {
+		t.Errorf("Expected the rofi index to pick line 5, got %q, %v", out, err)
+	}
+
+	// Editing the second duplicate from the main list changes only it
+	useMenu(t, "Title: Call mum", "Call dad", "Save item")
+	editItem(list.lookup(lines[1]), todoList)
+	if list.todos[4].Summary != "Call mum" || list.todos[5].Summary != "Call dad" {
+		t.Errorf("Expected only the second duplicate to change, got %q and %q", list.todos[4].Summary, list.todos[5].Summary)
+	}
+}
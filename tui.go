@@ -0,0 +1,327 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// tuiMenu is the built-in full-screen terminal Menu used with -cmd tui, for
+// SSH sessions and machines without a graphical launcher. It draws with
+// ANSI escapes and puts the terminal in raw mode with stty.
+type tuiMenu struct {
+	tty   *os.File
+	saved string // stty settings to restore on Close
+}
+
+func (t *tuiMenu) Show(list, prompt string) (string, error) {
+	out, _, err := t.run(newTUIList(list, prompt, nil, ""))
+	return out, err
+}
+
+func (t *tuiMenu) ShowKeys(list, prompt string, keys map[string]string) (string, string, error) {
+	return t.run(newTUIList(list, prompt, keys, ""))
+}
+
+func (t *tuiMenu) DefaultKeys() map[string]string {
+	return tuiKeys
+}
+
+func (t *tuiMenu) Prompt(prompt, current string) (string, error) {
+	out, _, err := t.run(newTUIList("", prompt, nil, current))
+	return out, err
+}
+
+func (t *tuiMenu) Confirm(question string) bool {
+	if err := t.open(); err != nil {
+		return false
+	}
+	fmt.Fprintf(t.tty, "\x1b[H\x1b[2J%s (y/N)", question)
+	key, err := t.readKey()
+	return err == nil && isYes(key)
+}
+
+// Close restores the terminal. It is safe to call when nothing was shown.
+func (t *tuiMenu) Close() error {
+	if t.tty == nil {
+		return nil
+	}
+	fmt.Fprint(t.tty, "\x1b[2J\x1b[?25h\x1b[?1049l")
+	_, err := stty(t.tty, t.saved)
+	t.tty.Close()
+	t.tty = nil
+	return err
+}
+
+// open switches the terminal to the alternate screen in raw mode the first
+// time it is needed.
+func (t *tuiMenu) open() error {
+	if t.tty != nil {
+		return nil
+	}
+	tty, err := os.OpenFile("/dev/tty", os.O_RDWR, 0)
+	if err != nil {
+		return err
+	}
+	saved, err := stty(tty, "-g")
+	if err == nil {
+		_, err = stty(tty, "raw", "-echo")
+	}
+	if err != nil {
+		tty.Close()
+		return fmt.Errorf("setting up terminal: %v", err)
+	}
+	fmt.Fprint(tty, "\x1b[?1049h\x1b[?25l")
+	t.tty, t.saved = tty, strings.TrimSpace(saved)
+	return nil
+}
+
+func (t *tuiMenu) run(l *tuiList) (string, string, error) {
+	if err := t.open(); err != nil {
+		return "", "", err
+	}
+	rows, cols := 24, 80
+	if size, err := stty(t.tty, "size"); err == nil {
+		var r, c int
+		if fmt.Sscan(size, &r, &c); r > 0 && c > 0 {
+			rows, cols = r, c
+		}
+	}
+	for {
+		fmt.Fprint(t.tty, l.render(rows, cols))
+		key, err := t.readKey()
+		if err != nil {
+			return "", "", err
+		}
+		if done, err := l.handle(key); done {
+			return l.result, l.pressed, err
+		}
+	}
+}
+
+func (t *tuiMenu) readKey() (string, error) {
+	buf := make([]byte, 64)
+	n, err := t.tty.Read(buf)
+	if err != nil {
+		return "", err
+	}
+	return decodeKey(buf[:n]), nil
+}
+
+func stty(tty *os.File, args ...string) (string, error) {
+	cmd := exec.Command("stty", args...)
+	cmd.Stdin = tty
+	out, err := cmd.Output()
+	return string(out), err
+}
+
+// decodeKey names the key in one read from the terminal. Escape sequences
+// arrive in a single read, so a lone ESC is the Esc key. Printable input is
+// returned as is and unknown control sequences as "".
+func decodeKey(b []byte) string {
+	switch string(b) {
+	case "\x1b":
+		return "esc"
+	case "\x03":
+		return "ctrl-c"
+	case "\r", "\n":
+		return "enter"
+	case "\x7f", "\b":
+		return "backspace"
+	case "\x15":
+		return "ctrl-u"
+	case "\x1b[A", "\x1bOA", "\x10":
+		return "up"
+	case "\x1b[B", "\x1bOB", "\x0e":
+		return "down"
+	case "\x1b[5~":
+		return "pgup"
+	case "\x1b[6~":
+		return "pgdn"
+	case "\x1b[H", "\x1bOH", "\x1b[1~":
+		return "home"
+	case "\x1b[F", "\x1bOF", "\x1b[4~":
+		return "end"
+	}
+	if len(b) == 0 || b[0] < 0x20 {
+		return ""
+	}
+	return string(b)
+}
+
+// tuiList is the state of one list screen, kept apart from the terminal so
+// it can be tested. Typing filters the list like dmenu does. With shortcut
+// keys the letters are shortcuts instead and "/" starts filtering.
+type tuiList struct {
+	prompt  string
+	items   []string
+	keys    map[string]string // Shortcut key to action name
+	query   []rune
+	typing  bool
+	matches []int // Indexes of the items matching query
+	cursor  int   // Position in matches
+	offset  int   // First match on screen
+	result  string
+	pressed string // Shortcut key that ended the screen
+}
+
+func newTUIList(list, prompt string, keys map[string]string, query string) *tuiList {
+	l := &tuiList{prompt: prompt, keys: keys, query: []rune(query), typing: len(keys) == 0}
+	if list != "" {
+		l.items = strings.Split(list, "\n")
+	}
+	l.filter()
+	return l
+}
+
+func (l *tuiList) filter() {
+	q := strings.ToLower(string(l.query))
+	l.matches = l.matches[:0]
+	for i, item := range l.items {
+		if q == "" || strings.Contains(strings.ToLower(item), q) {
+			l.matches = append(l.matches, i)
+		}
+	}
+	l.cursor = 0
+	l.move(0)
+}
+
+// move moves the cursor n matches, skipping blank separator lines and
+// group headings.
+func (l *tuiList) move(n int) {
+	if len(l.matches) == 0 {
+		return
+	}
+	step := 1
+	if n < 0 {
+		step = -1
+	}
+	c := min(max(l.cursor+n, 0), len(l.matches)-1)
+	for c >= 0 && c < len(l.matches) && isSeparator(l.items[l.matches[c]]) {
+		c += step
+	}
+	if c >= 0 && c < len(l.matches) {
+		l.cursor = c
+	}
+}
+
+// selected returns the highlighted line, or the typed text if nothing
+// matches.
+func (l *tuiList) selected() string {
+	if len(l.matches) == 0 {
+		return string(l.query)
+	}
+	return l.items[l.matches[l.cursor]]
+}
+
+// handle applies a key press and reports whether the screen is finished.
+// The error is errEscape if it was cancelled.
+func (l *tuiList) handle(key string) (done bool, err error) {
+	switch key {
+	case "esc", "ctrl-c":
+		if l.typing && len(l.keys) > 0 {
+			// Leave the filter and go back to shortcuts
+			l.typing, l.query = false, nil
+			l.filter()
+			return false, nil
+		}
+		return true, errEscape
+	case "enter":
+		l.result = l.selected()
+		return true, nil
+	case "up":
+		l.move(-1)
+	case "down":
+		l.move(1)
+	case "pgup":
+		l.move(-10)
+	case "pgdn":
+		l.move(10)
+	case "home":
+		l.move(-len(l.matches))
+	case "end":
+		l.move(len(l.matches))
+	case "backspace":
+		if len(l.query) > 0 {
+			l.query = l.query[:len(l.query)-1]
+			l.filter()
+		}
+	case "ctrl-u":
+		l.query = nil
+		l.filter()
+	case "":
+	default:
+		if l.typing {
+			l.query = append(l.query, []rune(key)...)
+			l.filter()
+			return false, nil
+		}
+		switch {
+		case key == "/":
+			l.typing = true
+		case key == "j":
+			l.move(1)
+		case key == "k":
+			l.move(-1)
+		case key == "q":
+			return true, errEscape
+		case l.keys[key] != "":
+			l.result, l.pressed = l.selected(), key
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// render draws the prompt line, the visible part of the list and, with
+// shortcut keys, a help line.
+func (l *tuiList) render(rows, cols int) string {
+	var b strings.Builder
+	b.WriteString("\x1b[H\x1b[2J")
+	if l.typing {
+		b.WriteString(truncate(l.prompt+" "+string(l.query), cols-1) + "\x1b[7m \x1b[0m")
+	} else {
+		b.WriteString(truncate(l.prompt, cols))
+	}
+
+	height := rows - 1
+	if len(l.keys) > 0 {
+		height--
+	}
+	height = max(height, 1)
+	if l.cursor < l.offset {
+		l.offset = l.cursor
+	}
+	if l.cursor >= l.offset+height {
+		l.offset = l.cursor - height + 1
+	}
+	for i := l.offset; i < len(l.matches) && i < l.offset+height; i++ {
+		line := truncate(l.items[l.matches[i]], cols)
+		if i == l.cursor {
+			line = "\x1b[7m" + line + "\x1b[0m"
+		}
+		b.WriteString("\r\n" + line)
+	}
+
+	if len(l.keys) > 0 {
+		help := []string{"enter select", "/ filter", "esc back"}
+		for _, key := range sortedKeys(l.keys) {
+			help = append(help, key+" "+l.keys[key])
+		}
+		fmt.Fprintf(&b, "\x1b[%d;1H\x1b[2m%s\x1b[0m", rows, truncate(strings.Join(help, "  "), cols))
+	}
+	return b.String()
+}
+
+func isSeparator(line string) bool {
+	return line == "" || isGroupHeader(line)
+}
+
+func truncate(s string, width int) string {
+	r := []rune(s)
+	if len(r) <= width {
+		return s
+	}
+	return string(r[:width])
+}
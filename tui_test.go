@@ -0,0 +1,157 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestDecodeKey(t *testing.T) {
+	tests := map[string]string{
+		"\x1b":    "esc",
+		"\x1b[A":  "up",
+		"\x1bOB":  "down",
+		"\r":      "enter",
+		"\x7f":    "backspace",
+		"a":       "a",
+		"é":       "é",
+		"\x1b[2~": "",
+	}
+	for in, expected := range tests {
+		if got := decodeKey([]byte(in)); got != expected {
+			t.Errorf("decodeKey(%q) = %q, expected %q", in, got, expected)
+		}
+	}
+}
+
+func pressKeys(l *tuiList, keys ...string) (bool, error) {
+	for _, key := range keys {
+		if done, err := l.handle(key); done {
+			return true, err
+		}
+	}
+	return false, nil
+}
+
+func TestTUIListFilter(t *testing.T) {
+	l := newTUIList("Save item\n\nTitle: Milk\nPriority: 0", "Edit", nil, "")
+	if done, err := pressKeys(l, "down", "enter"); !done || err != nil || l.result != "Title: Milk" {
+		t.Errorf("Expected down to skip the blank line, got %q, %v", l.result, err)
+	}
+
+	l = newTUIList("Save item\n\nTitle: Milk\nPriority: 0", "Edit", nil, "")
+	pressKeys(l, "p", "r", "i", "enter")
+	if l.result != "Priority: 0" {
+		t.Errorf("Expected typing to filter, got %q", l.result)
+	}
+
+	// Text that matches nothing is returned as typed
+	l = newTUIList("home\nwork", "Categories", nil, "")
+	pressKeys(l, "x", "y", "enter")
+	if l.result != "xy" {
+		t.Errorf("Expected typed text, got %q", l.result)
+	}
+
+	l = newTUIList("", "Title:", nil, "Milk")
+	pressKeys(l, "backspace", "backspace", "enter")
+	if l.result != "Mi" {
+		t.Errorf("Expected prompt to edit the current value, got %q", l.result)
+	}
+
+	l = newTUIList("a\nb", "Pick", nil, "")
+	if done, err := pressKeys(l, "esc"); !done || !errors.Is(err, errEscape) {
+		t.Errorf("Expected esc to cancel, got %v, %v", done, err)
+	}
+}
+
+func TestTUIListShortcuts(t *testing.T) {
+	list := "Add Item\nView Completed Items\n(1) Milk\n(2) Bread"
+	l := newTUIList(list, "todos", tuiKeys, "")
+	if done, _ := pressKeys(l, "j", "j", "c"); !done || l.result != "(1) Milk" || l.pressed != "c" {
+		t.Errorf("Expected c on Milk, got %q, %q", l.result, l.pressed)
+	}
+
+	// Letters filter after "/" and esc goes back to shortcuts
+	l = newTUIList(list, "todos", tuiKeys, "")
+	pressKeys(l, "/", "b", "r", "esc")
+	if l.typing || len(l.matches) != 4 {
+		t.Errorf("Expected esc to clear the filter, typing %v with %d matches", l.typing, len(l.matches))
+	}
+	pressKeys(l, "/", "b", "r", "enter")
+	if l.result != "(2) Bread" || l.pressed != "" {
+		t.Errorf("Expected filtered selection, got %q, %q", l.result, l.pressed)
+	}
+}
+
+func TestTUIListRender(t *testing.T) {
+	var items []string
+	for i := 0; i < 20; i++ {
+		items = append(items, strings.Repeat("x", i+1))
+	}
+	l := newTUIList(strings.Join(items, "\n"), "todos", tuiKeys, "")
+	pressKeys(l, "end")
+	screen := l.render(10, 15)
+	if !strings.Contains(screen, "\x1b[7m"+strings.Repeat("x", 15)+"\x1b[0m") {
+		t.Errorf("Expected the last item highlighted and truncated, got %q", screen)
+	}
+	if strings.Contains(screen, "\r\nx\r\n") {
+		t.Error("Expected the list to scroll past the first item")
+	}
+	if !strings.Contains(l.render(10, 200), "c complete") {
+		t.Error("Expected shortcut help")
+	}
+}
+
+// closingMenu records whether it was closed and can panic in Show.
+type closingMenu struct {
+	*scriptedMenu
+	panics bool
+	closed bool
+}
+
+func (c *closingMenu) Show(list, prompt string) (string, error) {
+	if c.panics {
+		panic("menu failed")
+	}
+	return c.scriptedMenu.Show(list, prompt)
+}
+
+func (c *closingMenu) Close() error {
+	c.closed = true
+	return nil
+}
+
+func TestRunMenuClosesMenu(t *testing.T) {
+	c := &closingMenu{scriptedMenu: useMenu(t, menuEscape)}
+	menu = c
+	runMenu(&TodoList{})
+	if !c.closed {
+		t.Error("Expected the menu to be closed on return")
+	}
+
+	// A panic in the menu still restores the terminal
+	c = &closingMenu{scriptedMenu: useMenu(t), panics: true}
+	menu = c
+	func() {
+		defer func() { recover() }()
+		runMenu(&TodoList{})
+	}()
+	if !c.closed {
+		t.Error("Expected the menu to be closed after a panic")
+	}
+}
+
+func TestRunMenuTodoLikeHeader(t *testing.T) {
+	useLineFormat(t, "{{.Summary}}")
+	todoList := &TodoList{Todos: []*Todo{
+		{UID: "a", Summary: "View: Work", Status: "NEEDS-ACTION"},
+		{UID: "b", Summary: "Filter: urgent", Status: "NEEDS-ACTION"},
+	}}
+	for _, todo := range todoList.Todos {
+		s := useMenu(t, todo.Summary, menuEscape, menuEscape)
+		runMenu(todoList)
+		if len(s.prompts) < 2 || s.prompts[1] != todo.Summary {
+			t.Errorf("Expected %q to open the todo, prompts were %q", todo.Summary, s.prompts)
+		}
+	}
+}
@@ -0,0 +1,298 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// contentLine is one unfolded line of an iCalendar file.
+type contentLine struct {
+	num    int // Line number of the first physical line
+	name   string
+	params string
+	value  string
+	text   string // The whole unfolded line
+	raw    string // The physical lines as read, with their line endings
+	valid  bool   // Whether the physical lines are CRLF terminated and short enough
+}
+
+// icsComponent is a BEGIN/END block and the properties directly in it.
+type icsComponent struct {
+	name       string
+	num        int
+	props      []contentLine
+	components []*icsComponent
+}
+
+func (c *icsComponent) count(name string) int {
+	n := 0
+	for _, p := range c.props {
+		if p.name == name {
+			n++
+		}
+	}
+	return n
+}
+
+func (c *icsComponent) get(name string) (contentLine, bool) {
+	for _, p := range c.props {
+		if p.name == name {
+			return p, true
+		}
+	}
+	return contentLine{}, false
+}
+
+var (
+	icsNameRe = regexp.MustCompile(`^[A-Za-z0-9-]+$`)
+	utcTimeRe = regexp.MustCompile(`^\d{8}T\d{6}Z$`)
+)
+
+// todoSingleProps may appear at most once in a VTODO (RFC 5545 3.6.2).
+var todoSingleProps = []string{
+	"DTSTAMP", "UID", "CLASS", "COMPLETED", "CREATED", "DESCRIPTION", "DTSTART",
+	"GEO", "LAST-MODIFIED", "LOCATION", "ORGANIZER", "PERCENT-COMPLETE",
+	"PRIORITY", "RECURRENCE-ID", "SEQUENCE", "STATUS", "SUMMARY", "URL",
+	"DUE", "DURATION",
+}
+
+// validateCalendar checks serialized iCalendar data against RFC 5545 before
+// it is written: line endings and folding, BEGIN/END nesting, the required
+// calendar properties, and the VTODO and VALARM rules. Other components are
+// only checked for structure. DTSTAMP and SEQUENCE are only required of the
+// todo uid being written, so a file shared with todos from other programs
+// that leave them out can still be saved. All problems found are returned.
+func validateCalendar(data []byte, uid string) error {
+	lines, errs := unfoldLines(data)
+	root, err := nestComponents(lines)
+	if err != nil {
+		return errors.Join(append(errs, err)...)
+	}
+	if root.name != "VCALENDAR" {
+		errs = append(errs, fmt.Errorf("line %d: expected VCALENDAR, got %s", root.num, root.name))
+	}
+	if v, ok := root.get("VERSION"); !ok || v.value != "2.0" || root.count("VERSION") > 1 {
+		errs = append(errs, fmt.Errorf("VCALENDAR needs one VERSION:2.0"))
+	}
+	if root.count("PRODID") != 1 {
+		errs = append(errs, fmt.Errorf("VCALENDAR needs one PRODID"))
+	}
+	if len(root.components) == 0 {
+		errs = append(errs, fmt.Errorf("VCALENDAR has no components"))
+	}
+	for _, c := range root.components {
+		if c.name == "VTODO" {
+			errs = append(errs, validateVTodo(c, uid)...)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// unfoldLines splits data into content lines, checking that every line ends
+// in CRLF and is at most 75 octets long.
+func unfoldLines(data []byte) ([]contentLine, []error) {
+	var errs []error
+	if !bytes.HasSuffix(data, []byte("\r\n")) {
+		errs = append(errs, fmt.Errorf("file doesn't end with CRLF"))
+	}
+	var lines []contentLine
+	var text, raw strings.Builder
+	start, valid := 0, true
+	flush := func() {
+		if text.Len() == 0 {
+			return
+		}
+		line, err := parseContentLine(text.String())
+		if err != nil {
+			errs = append(errs, fmt.Errorf("line %d: %v", start, err))
+		} else {
+			line.num, line.raw, line.valid = start, raw.String(), valid
+			lines = append(lines, line)
+		}
+		text.Reset()
+		raw.Reset()
+	}
+	physical := strings.SplitAfter(string(data), "\n")
+	for i, l := range physical {
+		if l == "" {
+			continue
+		}
+		num := i + 1
+		content := strings.TrimSuffix(l, "\n")
+		lineOK := true
+		if c, ok := strings.CutSuffix(content, "\r"); ok {
+			content = c
+		} else if strings.HasSuffix(l, "\n") {
+			errs = append(errs, fmt.Errorf("line %d: bare LF line ending", num))
+			lineOK = false
+		}
+		if len(content) > 75 {
+			errs = append(errs, fmt.Errorf("line %d: longer than 75 octets", num))
+			lineOK = false
+		}
+		if cont, ok := strings.CutPrefix(content, " "); ok {
+			text.WriteString(cont)
+			raw.WriteString(l)
+			valid = valid && lineOK
+			continue
+		}
+		if cont, ok := strings.CutPrefix(content, "\t"); ok {
+			text.WriteString(cont)
+			raw.WriteString(l)
+			valid = valid && lineOK
+			continue
+		}
+		flush()
+		if content == "" {
+			errs = append(errs, fmt.Errorf("line %d: empty line", num))
+			continue
+		}
+		start, valid = num, lineOK
+		text.WriteString(content)
+		raw.WriteString(l)
+	}
+	flush()
+	return lines, errs
+}
+
+// parseContentLine splits an unfolded line into its name, parameters and
+// value. Colons in quoted parameter values don't end the parameters.
+func parseContentLine(line string) (contentLine, error) {
+	quoted := false
+	for i, r := range line {
+		switch r {
+		case '"':
+			quoted = !quoted
+		case ':':
+			if quoted {
+				continue
+			}
+			name, params, _ := strings.Cut(line[:i], ";")
+			if !icsNameRe.MatchString(name) {
+				return contentLine{}, fmt.Errorf("bad property name %q", name)
+			}
+			return contentLine{name: strings.ToUpper(name), params: params, value: line[i+1:], text: line}, nil
+		}
+	}
+	return contentLine{}, fmt.Errorf("no value in %q", line)
+}
+
+// nestComponents builds the component tree from BEGIN and END lines. The
+// file must hold exactly one top level component.
+func nestComponents(lines []contentLine) (*icsComponent, error) {
+	var root *icsComponent
+	var stack []*icsComponent
+	for _, line := range lines {
+		switch line.name {
+		case "BEGIN":
+			c := &icsComponent{name: strings.ToUpper(line.value), num: line.num}
+			if len(stack) > 0 {
+				parent := stack[len(stack)-1]
+				parent.components = append(parent.components, c)
+			} else if root != nil {
+				return nil, fmt.Errorf("line %d: more than one top level component", line.num)
+			} else {
+				root = c
+			}
+			stack = append(stack, c)
+		case "END":
+			if len(stack) == 0 || !strings.EqualFold(stack[len(stack)-1].name, line.value) {
+				return nil, fmt.Errorf("line %d: unexpected END:%s", line.num, line.value)
+			}
+			stack = stack[:len(stack)-1]
+		default:
+			if len(stack) == 0 {
+				return nil, fmt.Errorf("line %d: %s outside a component", line.num, line.name)
+			}
+			c := stack[len(stack)-1]
+			c.props = append(c.props, line)
+		}
+	}
+	if len(stack) > 0 {
+		return nil, fmt.Errorf("missing END:%s", stack[len(stack)-1].name)
+	}
+	if root == nil {
+		return nil, fmt.Errorf("no components")
+	}
+	return root, nil
+}
+
+// validateVTodo checks a VTODO and its VALARMs. DTSTAMP and SEQUENCE are
+// only checked if it is the todo written.
+func validateVTodo(c *icsComponent, written string) []error {
+	uid := "without UID"
+	if p, ok := c.get("UID"); ok {
+		uid = p.value
+	}
+	stamped := []string{"DTSTAMP", "COMPLETED", "CREATED", "LAST-MODIFIED"}
+	required := []string{"UID", "DTSTAMP"}
+	if uid != written {
+		stamped, required = stamped[1:], required[:1]
+	}
+	var errs []error
+	fail := func(format string, args ...any) {
+		errs = append(errs, fmt.Errorf("VTODO %s: "+format, append([]any{uid}, args...)...))
+	}
+
+	for _, name := range required {
+		if c.count(name) == 0 {
+			fail("missing %s", name)
+		}
+	}
+	for _, name := range todoSingleProps {
+		if c.count(name) > 1 {
+			fail("more than one %s", name)
+		}
+	}
+	if c.count("DUE") > 0 && c.count("DURATION") > 0 {
+		fail("both DUE and DURATION")
+	}
+	if c.count("DURATION") > 0 && c.count("DTSTART") == 0 {
+		fail("DURATION without DTSTART")
+	}
+	for _, name := range stamped {
+		if p, ok := c.get(name); ok && !utcTimeRe.MatchString(p.value) {
+			fail("%s must be a UTC date-time, got %q", name, p.value)
+		}
+	}
+	if p, ok := c.get("STATUS"); ok && !slices.Contains(todoStatuses, p.value) {
+		fail("bad STATUS %q", p.value)
+	}
+	checkInt := func(name string, min, max int) {
+		p, ok := c.get(name)
+		if !ok {
+			return
+		}
+		if n, err := strconv.Atoi(p.value); err != nil || n < min || n > max {
+			fail("%s must be between %d and %d, got %q", name, min, max, p.value)
+		}
+	}
+	checkInt("PRIORITY", 0, 9)
+	checkInt("PERCENT-COMPLETE", 0, 100)
+	if uid == written {
+		checkInt("SEQUENCE", 0, 1<<31-1)
+	}
+
+	for _, alarm := range c.components {
+		if alarm.name != "VALARM" {
+			continue
+		}
+		for _, name := range []string{"ACTION", "TRIGGER"} {
+			if alarm.count(name) != 1 {
+				fail("VALARM on line %d needs one %s", alarm.num, name)
+			}
+		}
+		if action, _ := alarm.get("ACTION"); action.value == "DISPLAY" && alarm.count("DESCRIPTION") == 0 {
+			fail("DISPLAY VALARM on line %d has no DESCRIPTION", alarm.num)
+		}
+		if (alarm.count("DURATION") > 0) != (alarm.count("REPEAT") > 0) {
+			fail("VALARM on line %d needs both DURATION and REPEAT or neither", alarm.num)
+		}
+	}
+	return errs
+}
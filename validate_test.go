@@ -0,0 +1,176 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+
+	ics "github.com/arran4/golang-ical"
+)
+
+const validCalendar = "BEGIN:VCALENDAR\r\nVERSION:2.0\r\nPRODID:-//test//EN\r\n" +
+	"BEGIN:VTODO\r\nUID:a\r\nDTSTAMP:20240101T000000Z\r\nSUMMARY:Lo\r\n ng\r\n" +
+	"DESCRIPTION;ALTREP=\"cid:x\":Notes\r\n" +
+	"BEGIN:VALARM\r\nACTION:DISPLAY\r\nTRIGGER:-PT15M\r\nDESCRIPTION:Long\r\nEND:VALARM\r\n" +
+	"END:VTODO\r\nEND:VCALENDAR\r\n"
+
+func TestValidateCalendar(t *testing.T) {
+	if err := validateCalendar([]byte(validCalendar), "a"); err != nil {
+		t.Fatalf("Expected a valid calendar, got %v", err)
+	}
+
+	for _, test := range []struct {
+		replace, with, want string
+	}{
+		{"\r\n", "\n", "bare LF"},
+		{"SUMMARY:Lo", "SUMMARY:" + strings.Repeat("x", 80), "longer than 75"},
+		{"PRODID:-//test//EN\r\n", "", "needs one PRODID"},
+		{"VERSION:2.0", "VERSION:1.0", "VERSION:2.0"},
+		{"DTSTAMP:20240101T000000Z\r\n", "", "missing DTSTAMP"},
+		{"DTSTAMP:20240101T000000Z", "DTSTAMP:20240101T000000", "UTC date-time"},
+		{"UID:a\r\n", "UID:a\r\nUID:b\r\n", "more than one UID"},
+		{"UID:a\r\n", "UID:a\r\nSTATUS:DONE\r\n", "bad STATUS"},
+		{"UID:a\r\n", "UID:a\r\nPRIORITY:10\r\n", "PRIORITY must be"},
+		{"UID:a\r\n", "UID:a\r\nPERCENT-COMPLETE:-1\r\n", "PERCENT-COMPLETE must be"},
+		{"UID:a\r\n", "UID:a\r\nSEQUENCE:x\r\n", "SEQUENCE must be"},
+		{"UID:a\r\n", "UID:a\r\nDUE:20240102\r\nDURATION:P1D\r\n", "both DUE and DURATION"},
+		{"TRIGGER:-PT15M\r\n", "", "needs one TRIGGER"},
+		{"DESCRIPTION:Long\r\n", "", "has no DESCRIPTION"},
+		{"END:VALARM\r\n", "", "unexpected END:VTODO"},
+		{"SUMMARY:Lo", "SUMMARY Lo", "no value"},
+	} {
+		data := strings.Replace(validCalendar, test.replace, test.with, 1)
+		err := validateCalendar([]byte(data), "a")
+		if err == nil || !strings.Contains(err.Error(), test.want) {
+			t.Errorf("Expected an error containing %q for %q, got %v", test.want, test.with, err)
+		}
+	}
+}
+
+// TestSavedFilesValidate saves every testdata todo and checks the files
+// against RFC 5545.
+func TestSavedFilesValidate(t *testing.T) {
+	dir := copyTestdata(t)
+	todoList := mustLoad(t, dir)
+	for _, todo := range todoList.Todos {
+		todo.Modified = true
+	}
+	if err := saveTodos(todoList, dir); err != nil {
+		t.Fatal(err)
+	}
+	for _, todo := range todoList.Todos {
+		data, err := os.ReadFile(filepath.Join(dir, todo.FileName))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := validateCalendar(data, todo.UID); err != nil {
+			t.Errorf("%s: %v", todo.FileName, err)
+		}
+	}
+}
+
+func TestSaveDtstampAndSequence(t *testing.T) {
+	dir := t.TempDir()
+	todo := newTodo("")
+	todo.Summary = "Renew passport"
+	todo.Modified = true
+	todoList := &TodoList{Todos: []*Todo{todo}}
+	read := func() string {
+		t.Helper()
+		if err := saveTodos(todoList, dir); err != nil {
+			t.Fatal(err)
+		}
+		data, err := os.ReadFile(filepath.Join(dir, todo.FileName))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(data)
+	}
+
+	data := read()
+	if !regexp.MustCompile(`\r\nDTSTAMP:\d{8}T\d{6}Z\r\n`).MatchString(data) || !strings.Contains(data, "\r\nSEQUENCE:0\r\n") {
+		t.Errorf("Expected DTSTAMP and SEQUENCE:0 on a new todo:\n%s", data)
+	}
+
+	// Completing from the edit menu writes COMPLETED and bumps SEQUENCE
+	useMenu(t, "Complete item", "Save item")
+	editItem(todo, todoList)
+	data = read()
+	if !strings.Contains(data, "\r\nSEQUENCE:1\r\n") || !strings.Contains(data, "\r\nCOMPLETED:"+todo.Completed.UTC().Format("20060102T150405Z")) {
+		t.Errorf("Expected SEQUENCE:1 and COMPLETED after completing:\n%s", data)
+	}
+}
+
+func TestSaveCompletedWithoutTime(t *testing.T) {
+	dir := t.TempDir()
+	data := "BEGIN:VCALENDAR\r\nBEGIN:VTODO\r\nUID:done\r\nSUMMARY:Done elsewhere\r\n" +
+		"STATUS:COMPLETED\r\nSEQUENCE:4\r\nEND:VTODO\r\nEND:VCALENDAR\r\n"
+	if err := os.WriteFile(filepath.Join(dir, "done.ics"), []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	todoList := mustLoad(t, dir)
+	todoList.Todos[0].Summary = "Done somewhere else"
+	todoList.Todos[0].Modified = true
+	if err := saveTodos(todoList, dir); err != nil {
+		t.Fatal(err)
+	}
+	saved, _ := os.ReadFile(filepath.Join(dir, "done.ics"))
+	for _, want := range []string{"\r\nVERSION:2.0\r\n", "\r\nPRODID:", "\r\nCOMPLETED:", "\r\nSEQUENCE:5\r\n"} {
+		if !strings.Contains(string(saved), want) {
+			t.Errorf("Expected %q in:\n%s", want, saved)
+		}
+	}
+}
+
+func TestWriteCalendarFileRejectsInvalid(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "bad.ics")
+	if err := os.WriteFile(path, []byte(validCalendar), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cal := ics.NewCalendar()
+	cal.AddTodo("bad").SetProperty(ics.ComponentPropertyPriority, "12")
+	if err := writeCalendarFile(path, cal, nil, "bad"); err == nil {
+		t.Fatal("Expected an error writing an invalid calendar")
+	}
+	data, _ := os.ReadFile(path)
+	if string(data) != validCalendar {
+		t.Error("Expected the old file to be left alone")
+	}
+	if tmps, _ := filepath.Glob(filepath.Join(filepath.Dir(path), ".*.tmp")); len(tmps) > 0 {
+		t.Errorf("Expected no temporary files, got %v", tmps)
+	}
+}
+
+// TestSaveBesideTodoWithoutDtstamp saves and deletes a todo sharing its
+// file with one another program wrote without DTSTAMP.
+func TestSaveBesideTodoWithoutDtstamp(t *testing.T) {
+	dir := t.TempDir()
+	data := "BEGIN:VCALENDAR\r\nVERSION:2.0\r\nPRODID:-//test//EN\r\n" +
+		"BEGIN:VTODO\r\nUID:a\r\nDTSTAMP:20240101T000000Z\r\nSUMMARY:Stamped\r\nEND:VTODO\r\n" +
+		"BEGIN:VTODO\r\nUID:b\r\nSUMMARY:Unstamped\r\nEND:VTODO\r\n" +
+		"END:VCALENDAR\r\n"
+	path := filepath.Join(dir, "shared.ics")
+	if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	todoList := loadListTestDir(t, dir)
+	todo := findTodo(todoList, "a")
+	todo.Summary = "Stamped again"
+	todo.Modified = true
+	if err := saveTodos(todoList, dir); err != nil {
+		t.Fatalf("Expected the save to succeed, got %v", err)
+	}
+	saved, _ := os.ReadFile(path)
+	if !strings.Contains(string(saved), "SUMMARY:Stamped again\r\n") || !strings.Contains(string(saved), "UID:b\r\nSUMMARY:Unstamped\r\n") {
+		t.Errorf("Expected the edit saved and the other todo untouched:\n%s", saved)
+	}
+
+	if !deleteTodo(todo, todoList) {
+		t.Fatal("Expected the todo to be deleted")
+	}
+	if rest, _ := os.ReadFile(path); strings.Contains(string(rest), "UID:a") || !strings.Contains(string(rest), "UID:b") {
+		t.Errorf("Expected only the other todo left:\n%s", rest)
+	}
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// view is a named filter, sort order, line format and grouping, defined in
+// the config file:
+//
+//	[[views]]
+//	name = "Work – high priority"
+//	filter = "cat:work pri<=3"
+//	sort = "priority,due"
+//	format = "{{.Summary}} {{.RelDue}}"
+//	group = "due"
+//
+// Settings a view leaves out come from the flags.
+type view struct {
+	Name   string
+	Filter string
+	Sort   string
+	Format string
+	Group  string
+}
+
+// views are the views from the config file.
+var views []view
+
+// baseView holds the settings from the flags, used when no view is
+// selected and for the settings a view leaves out.
+var baseView view
+
+// currentView is the name of the selected view, "" for none.
+var currentView string
+
+// allItemsView is the menu entry for going back to the flag settings. A
+// view can't have its name.
+const allItemsView = "All items"
+
+// parseViews reads the [[views]] tables of the config file.
+func parseViews(value any) ([]view, error) {
+	tables, ok := value.([]map[string]any)
+	if inline, isArray := value.([]any); isArray {
+		// views = [{name = "Today", ...}]
+		ok = true
+		for _, item := range inline {
+			table, isTable := item.(map[string]any)
+			ok = ok && isTable
+			tables = append(tables, table)
+		}
+	}
+	if !ok {
+		return nil, fmt.Errorf("views must be [[views]] tables")
+	}
+	var parsed []view
+	seen := make(map[string]bool)
+	for _, table := range tables {
+		var v view
+		for _, key := range sortedConfigKeys(table) {
+			s, ok := table[key].(string)
+			if !ok {
+				return nil, fmt.Errorf("view %s must be a string", key)
+			}
+			switch key {
+			case "name":
+				v.Name = s
+			case "filter":
+				v.Filter = s
+			case "sort":
+				v.Sort = s
+			case "format":
+				v.Format = s
+			case "group":
+				v.Group = s
+			default:
+				return nil, fmt.Errorf("unknown view setting %q", key)
+			}
+		}
+		if v.Name == "" {
+			return nil, fmt.Errorf("view without a name")
+		}
+		if strings.EqualFold(strings.TrimSpace(v.Name), allItemsView) {
+			return nil, fmt.Errorf("view %q: the name is taken by the built-in view", v.Name)
+		}
+		if seen[strings.ToLower(v.Name)] {
+			return nil, fmt.Errorf("duplicate view %q", v.Name)
+		}
+		seen[strings.ToLower(v.Name)] = true
+		if err := checkView(v); err != nil {
+			return nil, fmt.Errorf("view %q: %v", v.Name, err)
+		}
+		parsed = append(parsed, v)
+	}
+	return parsed, nil
+}
+
+// checkView reports errors in a view's settings without applying them.
+func checkView(v view) error {
+	if v.Filter != "" {
+		if _, err := parseFilter(v.Filter); err != nil {
+			return fmt.Errorf("filter: %v", err)
+		}
+	}
+	if _, err := parseSort(v.Sort); err != nil {
+		return fmt.Errorf("sort: %v", err)
+	}
+	if _, err := parseLineFormat(v.Format); err != nil {
+		return fmt.Errorf("format: %v", err)
+	}
+	if v.Group != "" && groupTitle[v.Group] == nil {
+		return fmt.Errorf("group %q, use category, list or due", v.Group)
+	}
+	return nil
+}
+
+// findView returns the view called name, ignoring case.
+func findView(name string) *view {
+	for i := range views {
+		if strings.EqualFold(views[i].Name, name) {
+			return &views[i]
+		}
+	}
+	return nil
+}
+
+// selectView applies the named view over the flag settings, or just the
+// flag settings if name is "".
+func selectView(name string) error {
+	v := baseView
+	if name != "" {
+		found := findView(name)
+		if found == nil {
+			return fmt.Errorf("no view called %q", name)
+		}
+		if found.Filter != "" {
+			v.Filter = found.Filter
+		}
+		if found.Sort != "" {
+			v.Sort = found.Sort
+		}
+		if found.Format != "" {
+			v.Format = found.Format
+		}
+		if found.Group != "" {
+			v.Group = found.Group
+		}
+		name = found.Name
+	}
+	if err := checkView(v); err != nil {
+		return err
+	}
+	setFilter(v.Filter)
+	setSortOrder(v.Sort)
+	setLineFormat(v.Format)
+	*groupPtr = v.Group
+	currentView = name
+	return nil
+}
+
+// addViewLines adds the main menu entries for switching views.
+func addViewLines(list *menuList) {
+	if currentView != "" {
+		list.add("View: "+allItemsView, nil)
+	}
+	for _, v := range views {
+		if v.Name != currentView {
+			list.add("View: "+v.Name, nil)
+		}
+	}
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const viewsConfig = `
+sort = "summary"
+
+[[views]]
+name = "Today"
+filter = "due<=today"
+
+[[views]]
+name = "Work – high priority"
+filter = "cat:work pri<=3"
+sort = "priority"
+format = "{{.Priority}} {{.Summary}}"
+group = "category"
+`
+
+// useViews loads views from a config file for one test and restores the
+// view settings afterwards.
+func useViews(t *testing.T, config string) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.toml")
+	if err := os.WriteFile(path, []byte(config), 0644); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		views, baseView, currentView = nil, view{}, ""
+		activeFilter, lineTemplate, *groupPtr = nil, nil, ""
+		setSortOrder("")
+	})
+	flags := flag.NewFlagSet("test", flag.ContinueOnError)
+	sortFlag := flags.String("sort", "", "")
+	if err := loadConfig(path, flags); err != nil {
+		t.Fatal(err)
+	}
+	baseView = view{Sort: *sortFlag}
+}
+
+func TestParseViews(t *testing.T) {
+	useViews(t, viewsConfig)
+	if len(views) != 2 || views[1].Name != "Work – high priority" || views[1].Group != "category" {
+		t.Fatalf("Unexpected views %+v", views)
+	}
+	if baseView.Sort != "summary" {
+		t.Errorf("Expected the other settings to be applied, got sort %q", baseView.Sort)
+	}
+
+	// Views may also be an array of inline tables
+	useViews(t, `views = [{name = "Today", filter = "due<=today"}]`)
+	if len(views) != 1 || views[0].Filter != "due<=today" {
+		t.Errorf("Expected the inline view, got %+v", views)
+	}
+
+	for _, config := range []string{
+		"[[views]]\nfilter = \"cat:work\"",
+		"views = [\"Today\"]",
+		"[[views]]\nname = \"a\"\nfilter = \"status>open\"",
+		"[[views]]\nname = \"a\"\nsort = \"size\"",
+		"[[views]]\nname = \"a\"\ncolour = \"red\"",
+		"[[views]]\nname = \"a\"\n[[views]]\nname = \"A\"",
+		"[[views]]\nname = \"all Items\"",
+		"[views]\nname = \"a\"",
+	} {
+		path := filepath.Join(t.TempDir(), "config.toml")
+		os.WriteFile(path, []byte(config), 0644)
+		if err := loadConfig(path, flag.NewFlagSet("test", flag.ContinueOnError)); err == nil {
+			t.Errorf("Expected an error for %q", config)
+		}
+	}
+}
+
+func TestSelectView(t *testing.T) {
+	useViews(t, viewsConfig)
+	todoList := &TodoList{Todos: []*Todo{
+		{UID: "a", Summary: "Invoice", Categories: []string{"work"}, Priority: 2, Status: "NEEDS-ACTION"},
+		{UID: "b", Summary: "Archive", Categories: []string{"work"}, Priority: 7, Status: "NEEDS-ACTION"},
+		{UID: "c", Summary: "Budget", Categories: []string{"work"}, Priority: 1, Status: "NEEDS-ACTION"},
+	}}
+
+	if err := selectView(""); err != nil {
+		t.Fatal(err)
+	}
+	list := createMenu(todoList, openItems)
+	if list.lines[0] != "View: Today" || list.lines[1] != "View: Work – high priority" {
+		t.Errorf("Expected the views at the top of the menu, got %q", list.lines[:2])
+	}
+	if lines := list.todoLines(); len(lines) != 3 || !strings.HasSuffix(lines[0], "Archive @work") {
+		t.Errorf("Expected all items sorted by summary, got %q", lines)
+	}
+
+	if err := selectView("work – HIGH priority"); err != nil {
+		t.Fatal(err)
+	}
+	list = createMenu(todoList, openItems)
+	if currentView != "Work – high priority" || menuPrompt() != currentView {
+		t.Errorf("Expected the view to be current, got %q", currentView)
+	}
+	if list.lines[0] != "View: All items" || list.lines[1] != "View: Today" {
+		t.Errorf("Expected to switch back or to the other view, got %q", list.lines[:2])
+	}
+	if got := strings.Join(list.todoLines(), "|"); got != "1 Budget|2 Invoice" {
+		t.Errorf("Expected the view's filter, sort and format, got %q", got)
+	}
+	if !strings.Contains(list.String(), groupHeader("@work")) {
+		t.Errorf("Expected the view's grouping, got:\n%s", list)
+	}
+
+	// Back to the flag settings
+	if err := selectView(""); err != nil {
+		t.Fatal(err)
+	}
+	if activeFilter != nil || lineTemplate != nil || *groupPtr != "" || currentView != "" {
+		t.Error("Expected the view settings to be cleared")
+	}
+	if err := selectView("Nope"); err == nil {
+		t.Error("Expected an error for an unknown view")
+	}
+}